@@ -0,0 +1,514 @@
+package jepl
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Accumulator folds the arguments of an aggregate call, one document at a
+// time, into the aggregate value of a group. An accumulator is created per
+// group and window for every execution, leaving the parsed statement free
+// of evaluation state.
+type Accumulator interface {
+	// Add folds the argument values of the call evaluated against a document.
+	// ts is the event time of the document in unix nanoseconds if the
+	// aggregate or the statement uses event time, zero otherwise.
+	Add(ts int64, args []interface{})
+
+	// Merge folds the state of other, an accumulator of the same call.
+	Merge(other Accumulator)
+
+	// Result returns the aggregate value.
+	Result() interface{}
+}
+
+// ArgType is the type of an aggregate function argument.
+type ArgType int
+
+const (
+	// FieldArg is a single field reference, e.g. count(host).
+	FieldArg ArgType = iota
+	// ExprArg is a field, a constant or an arithmetic expression of them,
+	// e.g. sum(in + out) or sum(1).
+	ExprArg
+	// NumberArg is a numeric literal, e.g. the 95 of percentile(x, 95).
+	NumberArg
+	// StringArg is a string literal.
+	StringArg
+	// AnyArg is any expression, e.g. the arguments of scalar functions.
+	AnyArg
+	// FieldOrConstArg is a single field reference or a constant, e.g.
+	// count(host) or count(1).
+	FieldOrConstArg
+)
+
+// Aggregate describes an aggregate function.
+type Aggregate struct {
+	// Args lists the types of the arguments in order.
+	Args []ArgType
+
+	// Optional is the number of trailing arguments that may be omitted.
+	Optional int
+
+	// Variadic allows the last argument to be repeated.
+	Variadic bool
+
+	// EventTime requires the event time of every document, as read from
+	// the time field of the query, to be passed to Add. Without a time
+	// dimension a document lacking one is passed the latest time seen.
+	EventTime bool
+
+	// Validate, if set, checks a call once its arguments have the declared
+	// types, e.g. that a literal argument is within range.
+	Validate func(c *Call) error
+
+	// New returns an empty accumulator for a call with the given arguments.
+	// Literal arguments may be read up front to configure the accumulator.
+	New func(args []Expr) Accumulator
+}
+
+var (
+	aggregatesMu sync.RWMutex
+	aggregateFns = make(map[string]*Aggregate)
+)
+
+func init() {
+	RegisterAggregate("sum", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &sumAccumulator{} }})
+	RegisterAggregate("avg", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &avgAccumulator{} }})
+	RegisterAggregate("max", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &maxAccumulator{} }})
+	RegisterAggregate("min", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &minAccumulator{} }})
+	RegisterAggregate("count", &Aggregate{Args: []ArgType{FieldOrConstArg}, Optional: 1, New: func([]Expr) Accumulator { return &countAccumulator{} }})
+	RegisterAggregate("mean", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &avgAccumulator{} }})
+	RegisterAggregate("first", &Aggregate{Args: []ArgType{ExprArg}, EventTime: true, New: func([]Expr) Accumulator { return &firstAccumulator{} }})
+	RegisterAggregate("last", &Aggregate{Args: []ArgType{ExprArg}, EventTime: true, New: func([]Expr) Accumulator { return &lastAccumulator{} }})
+	RegisterAggregate("spread", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &spreadAccumulator{} }})
+	RegisterAggregate("variance", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &varianceAccumulator{} }})
+	RegisterAggregate("stddev", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &varianceAccumulator{stddev: true} }})
+	RegisterAggregate("mode", &Aggregate{Args: []ArgType{ExprArg}, New: func([]Expr) Accumulator { return &modeAccumulator{counts: make(map[interface{}]int)} }})
+}
+
+// RegisterAggregate makes an aggregate function available to statements
+// parsed afterwards. It panics if agg is invalid or name is already registered
+// as an aggregate or a function.
+func RegisterAggregate(name string, agg *Aggregate) {
+	if agg == nil || agg.New == nil {
+		panic("jepl: RegisterAggregate aggregate is nil")
+	}
+	if agg.Optional > len(agg.Args) || (agg.Variadic && len(agg.Args) == 0) {
+		panic("jepl: RegisterAggregate invalid arguments for " + name)
+	}
+
+	name = strings.ToLower(name)
+	if _, ok := lookupFunction(name); ok {
+		panic("jepl: RegisterAggregate called for function " + name)
+	}
+	aggregatesMu.Lock()
+	defer aggregatesMu.Unlock()
+	if _, dup := aggregateFns[name]; dup {
+		panic("jepl: RegisterAggregate called twice for " + name)
+	}
+	aggregateFns[name] = agg
+}
+
+// lookupAggregate returns the aggregate function registered as name.
+func lookupAggregate(name string) (*Aggregate, bool) {
+	aggregatesMu.RLock()
+	defer aggregatesMu.RUnlock()
+	agg, ok := aggregateFns[strings.ToLower(name)]
+	return agg, ok
+}
+
+// validate checks the arguments of c against the signature of the aggregate.
+func (agg *Aggregate) validate(c *Call) error {
+	if err := validateCallArgs(c, agg.Args, agg.Optional, agg.Variadic); err != nil {
+		return err
+	}
+	if agg.Validate != nil {
+		return agg.Validate(c)
+	}
+	return nil
+}
+
+// validateCallArgs checks the number and types of the arguments of c.
+func validateCallArgs(c *Call, types []ArgType, optional int, variadic bool) error {
+	min, max := len(types)-optional, len(types)
+	if n := len(c.Args); n < min || (n > max && !variadic) {
+		switch {
+		case variadic:
+			return fmt.Errorf("invalid number of arguments for %s, expected at least %d, got %d", c.Name, min, n)
+		case min == max:
+			return fmt.Errorf("invalid number of arguments for %s, expected %d, got %d", c.Name, min, n)
+		default:
+			return fmt.Errorf("invalid number of arguments for %s, expected %d to %d, got %d", c.Name, min, max, n)
+		}
+	}
+
+	for i, arg := range c.Args {
+		typ := types[len(types)-1]
+		if i < len(types) {
+			typ = types[i]
+		}
+
+		switch typ {
+		case FieldArg:
+			if _, ok := arg.(*VarRef); !ok {
+				return fmt.Errorf("expected only field argument in %s()", c.Name)
+			}
+		case FieldOrConstArg:
+			switch arg.(type) {
+			case *VarRef, *NumberLiteral, *IntegerLiteral, *StringLiteral, *BooleanLiteral:
+			default:
+				return fmt.Errorf("expected only field argument in %s()", c.Name)
+			}
+		case ExprArg:
+			switch arg := arg.(type) {
+			case *VarRef, *NumberLiteral, *IntegerLiteral:
+			case *BinaryExpr:
+				if err := arg.validateArgs(); err != nil {
+					return err
+				}
+			case *UnaryExpr:
+				if err := validateArgExpr(arg); err != nil {
+					return err
+				}
+			case *Call:
+				if _, ok := lookupFunction(arg.Name); !ok {
+					return fmt.Errorf("expected field argument in %s()", c.Name)
+				}
+				if err := validateArgExpr(arg); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("expected field argument in %s()", c.Name)
+			}
+		case NumberArg:
+			switch arg.(type) {
+			case *NumberLiteral, *IntegerLiteral:
+			default:
+				return fmt.Errorf("expected number argument in %s()", c.Name)
+			}
+		case StringArg:
+			if _, ok := arg.(*StringLiteral); !ok {
+				return fmt.Errorf("expected string argument in %s()", c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// usesEventTime returns true if an aggregate of the statement needs the
+// event time of each document.
+func (s *SelectStatement) usesEventTime() bool {
+	for _, c := range s.FunctionCalls() {
+		if agg, ok := lookupAggregate(c.Name); ok && agg.EventTime {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregates holds the accumulators of the aggregate calls of a statement
+// for a single group and window.
+type aggregates map[*Call]Accumulator
+
+// newAggregates returns empty accumulators for the aggregate calls of s.
+func newAggregates(s *SelectStatement) aggregates {
+	a := make(aggregates)
+	for _, c := range s.FunctionCalls() {
+		if agg, ok := lookupAggregate(c.Name); ok {
+			a[c] = agg.New(c.Args)
+		}
+	}
+	return a
+}
+
+// add folds js, an event at ts, into every accumulator whose filter it passes.
+func (a aggregates) add(ts int64, js *string, valuer Valuer) {
+	for c, acc := range a {
+		if c.Filter != nil {
+			if ok, _ := EvalWithValuer(c.Filter, js, valuer).(bool); !ok {
+				continue
+			}
+		}
+		args := make([]interface{}, len(c.Args))
+		for i, arg := range c.Args {
+			args[i] = EvalWithValuer(arg, js, valuer)
+		}
+		acc.Add(ts, args)
+	}
+}
+
+// merge folds the accumulators of other into a.
+func (a aggregates) merge(other aggregates) {
+	for c, acc := range a {
+		if o, ok := other[c]; ok {
+			acc.Merge(o)
+		}
+	}
+}
+
+// values returns the result of every accumulator keyed by its call.
+func (a aggregates) values() map[*Call]interface{} {
+	m := make(map[*Call]interface{}, len(a))
+	for c, acc := range a {
+		m[c] = acc.Result()
+	}
+	return m
+}
+
+// number returns v as a float64 if it is numeric.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+type sumAccumulator struct {
+	sum float64
+}
+
+func (a *sumAccumulator) Add(ts int64, args []interface{}) {
+	if v, ok := number(args[0]); ok {
+		a.sum += v
+	}
+}
+
+func (a *sumAccumulator) Merge(other Accumulator) { a.sum += other.(*sumAccumulator).sum }
+
+func (a *sumAccumulator) Result() interface{} { return a.sum }
+
+// avgAccumulator divides the sum by the number of documents, so documents
+// missing the field count as zero.
+type avgAccumulator struct {
+	sum   float64
+	count int
+}
+
+func (a *avgAccumulator) Add(ts int64, args []interface{}) {
+	if v, ok := number(args[0]); ok {
+		a.sum += v
+	}
+	a.count++
+}
+
+func (a *avgAccumulator) Merge(other Accumulator) {
+	o := other.(*avgAccumulator)
+	a.sum += o.sum
+	a.count += o.count
+}
+
+func (a *avgAccumulator) Result() interface{} {
+	if a.count == 0 {
+		return float64(0)
+	}
+	return a.sum / float64(a.count)
+}
+
+type maxAccumulator struct {
+	max float64
+	ok  bool
+}
+
+func (a *maxAccumulator) Add(ts int64, args []interface{}) {
+	if v, ok := number(args[0]); ok && (!a.ok || v > a.max) {
+		a.max, a.ok = v, true
+	}
+}
+
+func (a *maxAccumulator) Merge(other Accumulator) {
+	if o := other.(*maxAccumulator); o.ok {
+		a.Add(0, []interface{}{o.max})
+	}
+}
+
+func (a *maxAccumulator) Result() interface{} { return a.max }
+
+type minAccumulator struct {
+	min float64
+	ok  bool
+}
+
+func (a *minAccumulator) Add(ts int64, args []interface{}) {
+	if v, ok := number(args[0]); ok && (!a.ok || v < a.min) {
+		a.min, a.ok = v, true
+	}
+}
+
+func (a *minAccumulator) Merge(other Accumulator) {
+	if o := other.(*minAccumulator); o.ok {
+		a.Add(0, []interface{}{o.min})
+	}
+}
+
+func (a *minAccumulator) Result() interface{} { return a.min }
+
+// countAccumulator counts the events of count() and count(*), or the events
+// of count(field) where the field is present and not null.
+type countAccumulator struct {
+	count int
+}
+
+func (a *countAccumulator) Add(ts int64, args []interface{}) {
+	if len(args) == 0 || args[0] != nil {
+		a.count++
+	}
+}
+
+func (a *countAccumulator) Merge(other Accumulator) { a.count += other.(*countAccumulator).count }
+
+func (a *countAccumulator) Result() interface{} { return float64(a.count) }
+
+// firstAccumulator selects the value of the earliest event. Of events at
+// the same time the first one added wins.
+type firstAccumulator struct {
+	val interface{}
+	ts  int64
+}
+
+func (a *firstAccumulator) Add(ts int64, args []interface{}) {
+	if args[0] != nil && (a.val == nil || ts < a.ts) {
+		a.val, a.ts = args[0], ts
+	}
+}
+
+func (a *firstAccumulator) Merge(other Accumulator) {
+	o := other.(*firstAccumulator)
+	a.Add(o.ts, []interface{}{o.val})
+}
+
+func (a *firstAccumulator) Result() interface{} { return a.val }
+
+// lastAccumulator selects the value of the latest event. Of events at the
+// same time the last one added wins.
+type lastAccumulator struct {
+	val interface{}
+	ts  int64
+}
+
+func (a *lastAccumulator) Add(ts int64, args []interface{}) {
+	if args[0] != nil && (a.val == nil || ts >= a.ts) {
+		a.val, a.ts = args[0], ts
+	}
+}
+
+func (a *lastAccumulator) Merge(other Accumulator) {
+	o := other.(*lastAccumulator)
+	a.Add(o.ts, []interface{}{o.val})
+}
+
+func (a *lastAccumulator) Result() interface{} { return a.val }
+
+// spreadAccumulator computes the difference between the largest and the
+// smallest value.
+type spreadAccumulator struct {
+	min, max float64
+	ok       bool
+}
+
+func (a *spreadAccumulator) Add(ts int64, args []interface{}) {
+	v, ok := number(args[0])
+	if !ok {
+		return
+	}
+	if !a.ok || v < a.min {
+		a.min = v
+	}
+	if !a.ok || v > a.max {
+		a.max = v
+	}
+	a.ok = true
+}
+
+func (a *spreadAccumulator) Merge(other Accumulator) {
+	if o := other.(*spreadAccumulator); o.ok {
+		a.Add(0, []interface{}{o.min})
+		a.Add(0, []interface{}{o.max})
+	}
+}
+
+func (a *spreadAccumulator) Result() interface{} { return a.max - a.min }
+
+// varianceAccumulator computes the sample variance, or its square root,
+// with Welford's online algorithm, which avoids the cancellation of
+// summing squares. Partial results are combined as described by Chan et al.
+type varianceAccumulator struct {
+	stddev bool
+	n      float64
+	mean   float64
+	m2     float64 // sum of squared differences from the mean
+}
+
+func (a *varianceAccumulator) Add(ts int64, args []interface{}) {
+	v, ok := number(args[0])
+	if !ok {
+		return
+	}
+	a.n++
+	delta := v - a.mean
+	a.mean += delta / a.n
+	a.m2 += delta * (v - a.mean)
+}
+
+func (a *varianceAccumulator) Merge(other Accumulator) {
+	o := other.(*varianceAccumulator)
+	if o.n == 0 {
+		return
+	}
+	n := a.n + o.n
+	delta := o.mean - a.mean
+	a.m2 += o.m2 + delta*delta*a.n*o.n/n
+	a.mean += delta * o.n / n
+	a.n = n
+}
+
+func (a *varianceAccumulator) Result() interface{} {
+	if a.n < 2 {
+		return nil
+	}
+	v := a.m2 / (a.n - 1)
+	if a.stddev {
+		return math.Sqrt(v)
+	}
+	return v
+}
+
+// modeAccumulator selects the most frequent number, string or boolean.
+// Of values equally frequent the smallest wins, numbers before strings
+// before booleans.
+type modeAccumulator struct {
+	counts map[interface{}]int
+}
+
+func (a *modeAccumulator) Add(ts int64, args []interface{}) {
+	switch v := args[0].(type) {
+	case string, bool:
+		a.counts[v]++
+	default:
+		if v, ok := number(v); ok {
+			a.counts[v]++
+		}
+	}
+}
+
+func (a *modeAccumulator) Merge(other Accumulator) {
+	for v, n := range other.(*modeAccumulator).counts {
+		a.counts[v] += n
+	}
+}
+
+func (a *modeAccumulator) Result() interface{} {
+	var mode interface{}
+	var max int
+	for v, n := range a.counts {
+		if n > max || (n == max && compareValues(v, mode) < 0) {
+			mode, max = v, n
+		}
+	}
+	return mode
+}
@@ -0,0 +1,312 @@
+package jepl_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure the built-in aggregates fold documents, skipping missing values.
+func TestAggregates(t *testing.T) {
+	docs := []string{
+		`{"x": 4}`,
+		`{"x": -2}`,
+		`{"y": 1}`,
+		`{"x": 7}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+	}{
+		{s: `select sum(x), count(x) from foo`, exp: []float64{9, 3}},
+		{s: `select max(x), min(x) from foo`, exp: []float64{7, -2}},
+		{s: `select avg(x) from foo`, exp: []float64{2.25}},
+		{s: `select max(y) - min(y), sum(x) / count(x) from foo`, exp: []float64{0, 3}},
+		{s: `select max(z), min(z) from foo`, exp: []float64{0, 0}},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure count(), count(*) and count of a constant count every event, while
+// count(field) skips events where the field is missing or null.
+func TestAggregates_Count(t *testing.T) {
+	docs := []string{
+		`{"x": 4, "s": "a"}`,
+		`{"x": null}`,
+		`{"y": 1}`,
+		`{"x": false, "s": ""}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+	}{
+		{s: `select count(*), count() from foo`, exp: []float64{4, 4}},
+		{s: `select count(1), count('a'), count(true), count(0.5) from foo`, exp: []float64{4, 4, 4, 4}},
+		{s: `select count(1) FILTER (WHERE x IS NOT NULL) from foo`, exp: []float64{2}},
+		{s: `select count(x), count(s), count(z) from foo`, exp: []float64{2, 2, 0}},
+		{s: `select count(*) FILTER (WHERE y = 1) from foo`, exp: []float64{1}},
+		{s: `select count(x) / count(*) from foo`, exp: []float64{0.5}},
+		{s: `select sum(1), sum(2 * 3), avg(1) from foo`, exp: []float64{4, 24, 1}},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// rangeAccumulator is a custom aggregate folding values into their range,
+// plus an optional offset.
+type rangeAccumulator struct {
+	min, max, offset float64
+	n                int
+}
+
+func (a *rangeAccumulator) Add(ts int64, args []interface{}) {
+	v, ok := args[0].(float64)
+	if !ok {
+		return
+	}
+	if a.n == 0 || v < a.min {
+		a.min = v
+	}
+	if a.n == 0 || v > a.max {
+		a.max = v
+	}
+	a.n++
+}
+
+func (a *rangeAccumulator) Merge(other jepl.Accumulator) {
+	o := other.(*rangeAccumulator)
+	if o.n > 0 {
+		a.Add(0, []interface{}{o.min})
+		a.Add(0, []interface{}{o.max})
+	}
+}
+
+func (a *rangeAccumulator) Result() interface{} { return a.max - a.min + a.offset }
+
+func init() {
+	jepl.RegisterAggregate("test_range", &jepl.Aggregate{
+		Args:     []jepl.ArgType{jepl.ExprArg, jepl.NumberArg},
+		Optional: 1,
+		New: func(args []jepl.Expr) jepl.Accumulator {
+			a := &rangeAccumulator{}
+			if len(args) > 1 {
+				a.offset = float64(args[1].(*jepl.IntegerLiteral).Val)
+			}
+			return a
+		},
+	})
+}
+
+// Ensure registered aggregates are validated and evaluated.
+func TestRegisterAggregate(t *testing.T) {
+	docs := []string{`{"x": 4}`, `{"x": -2}`, `{"x": 7}`}
+
+	for i, tt := range []struct {
+		s   string
+		exp float64
+		err string
+	}{
+		{s: `select test_range(x) from foo`, exp: 9},
+		{s: `select TEST_RANGE(x * 2, 1) from foo`, exp: 19},
+		{s: `select test_range() from foo`, err: `invalid number of arguments for test_range, expected 1 to 2, got 0`},
+		{s: `select test_range(x, y) from foo`, err: `expected number argument in test_range()`},
+		{s: `select test_range('a') from foo`, err: `expected field argument in test_range()`},
+		{s: `select test_rang(x) from foo`, err: `undefined aggregate function test_rang()`},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if got := res.Get("").Points[0].Metric; got != tt.exp {
+			t.Errorf("%d. %q: exp=%v got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure registering an aggregate twice panics.
+func TestRegisterAggregate_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	jepl.RegisterAggregate("sum", &jepl.Aggregate{
+		Args: []jepl.ArgType{jepl.ExprArg},
+		New:  func([]jepl.Expr) jepl.Accumulator { return nil },
+	})
+}
+
+// Ensure the statistical aggregates and selectors evaluate, including
+// across merged sessions.
+func TestAggregates_Stats(t *testing.T) {
+	docs := []string{
+		`{"@timestamp": 30, "x": 2}`,
+		`{"@timestamp": 10, "x": 4}`,
+		`{"@timestamp": 20, "x": 4}`,
+		`{"@timestamp": 90, "y": 1}`,
+		`{"@timestamp": 180, "x": 5}`,
+		`{"@timestamp": 180, "x": 7}`,
+		`{"@timestamp": 170, "x": 5}`,
+		`{"@timestamp": 150, "x": 9}`,
+		`{"@timestamp": 100, "x": 4}`, // bridges the sessions at 10 and 150
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+	}{
+		{s: `select first(x), last(x) from foo`, exp: []float64{4, 7}},
+		{s: `select spread(x), mean(x) from foo`, exp: []float64{7, 40.0 / 9}},
+		{s: `select variance(x), stddev(x) from foo`, exp: []float64{32.0 / 7, math.Sqrt(32.0 / 7)}},
+		{s: `select mode(x) from foo`, exp: []float64{4}},
+		{s: `select first(x), last(x), spread(x), variance(x), mode(x) from foo group by session(2m)`, exp: []float64{4, 7, 7, 32.0 / 7, 4}},
+		{s: `select first(x), stddev(x), mode(x) from foo group by time(1m)`, exp: []float64{
+			4, math.Sqrt(4.0 / 3), 4,
+			4, 0, 4,
+			9, 2 * math.Sqrt2, 5,
+			5, math.Sqrt2, 5,
+		}},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if len(got) != len(tt.exp) {
+			t.Fatalf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+		for j := range got {
+			if math.Abs(got[j]-tt.exp[j]) > 1e-9 {
+				t.Errorf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+				break
+			}
+		}
+	}
+}
+
+// Ensure events without a timestamp are still aggregated, ordered by
+// arrival for selectors ordered by event time.
+func TestAggregates_EventTime(t *testing.T) {
+	for i, tt := range []struct {
+		docs []string
+		exp  []float64
+	}{
+		{docs: []string{`{"@timestamp": 1, "x": 1}`, `{"x": 2}`, `{"@timestamp": 3, "x": 4}`}, exp: []float64{7, 1, 4}},
+		{docs: []string{`{"x": 2}`, `{"@timestamp": 1, "x": 1}`, `{"@timestamp": "bad", "x": 4}`}, exp: []float64{7, 2, 4}},
+		{docs: []string{`{"x": 2}`, `{"@timestamp": null, "x": 1}`}, exp: []float64{3, 2, 1}},
+	} {
+		res, err := jepl.ExecSQL(`select sum(x), first(x), last(x) from foo`, tt.docs)
+		if err != nil {
+			t.Errorf("%d. unexpected error: %v", i, err)
+			continue
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. metrics mismatch:\n  exp=%v\n  got=%v", i, tt.exp, got)
+		}
+	}
+}
+
+// Ensure mode() counts strings and booleans as well as numbers.
+func TestAggregates_Mode(t *testing.T) {
+	docs := []string{
+		`{"host": "b", "up": true, "x": 2}`,
+		`{"host": "a", "up": false, "x": "2"}`,
+		`{"host": "b", "up": false, "x": 2}`,
+		`{"host": "a", "up": true, "x": "2"}`,
+		`{"host": "c", "x": {"y": 1}}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp []interface{}
+	}{
+		{s: `select mode(host) from foo`, exp: []interface{}{"a"}},
+		{s: `select mode(up) from foo`, exp: []interface{}{false}},
+		{s: `select mode(x) from foo`, exp: []interface{}{float64(2)}},
+		{s: `select mode(host) from foo where host = 'c'`, exp: []interface{}{"c"}},
+		{s: `select mode(missing) from foo`, exp: []interface{}{nil}},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		if rows := res.Get("").Rows; len(rows) != 1 || !reflect.DeepEqual(tt.exp, rows[0].Values) {
+			t.Errorf("%d. %q: rows mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, rows)
+		}
+	}
+}
+
+// Ensure aggregate filters restrict the events folded by each aggregate.
+func TestAggregates_Filter(t *testing.T) {
+	docs := []string{
+		`{"status": 200, "bytes": 10, "proto": "tcp"}`,
+		`{"status": 503, "bytes": 20, "proto": "udp"}`,
+		`{"status": 500, "bytes": 40, "proto": "tcp"}`,
+		`{"status": 404, "bytes": 80}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+		err string
+	}{
+		{s: `select count(status) FILTER (WHERE status >= 500), count(status) from foo`, exp: []float64{2, 4}},
+		{s: `select count(status) filter (where status >= 500) / count(status) AS error_rate from foo`, exp: []float64{0.5}},
+		{s: `select sum(bytes) FILTER (WHERE proto = 'udp' OR proto = 'tcp'), sum(bytes) FILTER (WHERE proto != 'tcp') from foo`, exp: []float64{70, 20}},
+		{s: `select count(DISTINCT proto) FILTER (WHERE lower(proto) =~ /^t/) from foo`, exp: []float64{1}},
+		{s: `select max(bytes) FILTER (WHERE status < 500) from foo where bytes > 10`, exp: []float64{80}},
+		{s: `select count(status) FILTER (WHERE sum(bytes) > 1) from foo`, err: `invalid filter, unsupport function sum(bytes)`},
+		{s: `select sum(abs(bytes) FILTER (WHERE bytes > 1)) from foo`, err: `FILTER is only supported by aggregate functions, got abs()`},
+		{s: `select count(status) FILTER (WHERE proto > 'a') from foo`, err: `invalid filter, unsupport op > for string`},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
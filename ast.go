@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"regexp"
 	"regexp/syntax"
 	"strconv"
@@ -33,6 +34,9 @@ var (
 	// ErrInvalidTime is returned when the timestamp string used to
 	// compare against time field is invalid.
 	ErrInvalidTime = errors.New("invalid timestamp string")
+
+	// ErrInvalidDuration is returned when parsing a malformed duration.
+	ErrInvalidDuration = errors.New("invalid duration")
 )
 
 // InspectDataType returns the data type of a given value.
@@ -86,22 +90,31 @@ func (Statements) node() {}
 
 func (*SelectStatement) node() {}
 
-func (*BinaryExpr) node()     {}
-func (*BooleanLiteral) node() {}
-func (*Call) node()           {}
-func (*IntegerLiteral) node() {}
-func (*Field) node()          {}
-func (Fields) node()          {}
-func (*Measurement) node()    {}
-func (Measurements) node()    {}
-func (*nilLiteral) node()     {}
-func (*NumberLiteral) node()  {}
-func (*ParenExpr) node()      {}
-func (*RegexLiteral) node()   {}
-func (*ListLiteral) node()    {}
-func (Sources) node()         {}
-func (*StringLiteral) node()  {}
-func (*VarRef) node()         {}
+func (*BinaryExpr) node()      {}
+func (*BooleanLiteral) node()  {}
+func (*BoundParameter) node()  {}
+func (*Call) node()            {}
+func (*CIDRLiteral) node()     {}
+func (*DurationLiteral) node() {}
+func (*IPLiteral) node()       {}
+func (*IntegerLiteral) node()  {}
+func (*Field) node()           {}
+func (Fields) node()           {}
+func (*Measurement) node()     {}
+func (Measurements) node()     {}
+func (*nilLiteral) node()      {}
+func (*NullLiteral) node()     {}
+func (*NumberLiteral) node()   {}
+func (*ParenExpr) node()       {}
+func (*RegexLiteral) node()    {}
+func (*ListLiteral) node()     {}
+func (*SortField) node()       {}
+func (SortFields) node()       {}
+func (Sources) node()          {}
+func (*TimeLiteral) node()     {}
+func (*StringLiteral) node()   {}
+func (*UnaryExpr) node()       {}
+func (*VarRef) node()          {}
 
 // Statements represents a list of statements.
 type Statements []Statement
@@ -136,17 +149,24 @@ type Expr interface {
 	expr()
 }
 
-func (*BinaryExpr) expr()     {}
-func (*BooleanLiteral) expr() {}
-func (*Call) expr()           {}
-func (*IntegerLiteral) expr() {}
-func (*nilLiteral) expr()     {}
-func (*NumberLiteral) expr()  {}
-func (*ParenExpr) expr()      {}
-func (*RegexLiteral) expr()   {}
-func (*ListLiteral) expr()    {}
-func (*StringLiteral) expr()  {}
-func (*VarRef) expr()         {}
+func (*BinaryExpr) expr()      {}
+func (*BooleanLiteral) expr()  {}
+func (*BoundParameter) expr()  {}
+func (*Call) expr()            {}
+func (*CIDRLiteral) expr()     {}
+func (*DurationLiteral) expr() {}
+func (*IPLiteral) expr()       {}
+func (*IntegerLiteral) expr()  {}
+func (*nilLiteral) expr()      {}
+func (*NullLiteral) expr()     {}
+func (*NumberLiteral) expr()   {}
+func (*ParenExpr) expr()       {}
+func (*RegexLiteral) expr()    {}
+func (*ListLiteral) expr()     {}
+func (*TimeLiteral) expr()     {}
+func (*StringLiteral) expr()   {}
+func (*UnaryExpr) expr()       {}
+func (*VarRef) expr()          {}
 
 // Literal represents a static literal.
 type Literal interface {
@@ -154,13 +174,19 @@ type Literal interface {
 	literal()
 }
 
-func (*BooleanLiteral) literal() {}
-func (*IntegerLiteral) literal() {}
-func (*nilLiteral) literal()     {}
-func (*NumberLiteral) literal()  {}
-func (*RegexLiteral) literal()   {}
-func (*ListLiteral) literal()    {}
-func (*StringLiteral) literal()  {}
+func (*BooleanLiteral) literal()  {}
+func (*BoundParameter) literal()  {}
+func (*CIDRLiteral) literal()     {}
+func (*DurationLiteral) literal() {}
+func (*IPLiteral) literal()       {}
+func (*IntegerLiteral) literal()  {}
+func (*nilLiteral) literal()      {}
+func (*NullLiteral) literal()     {}
+func (*NumberLiteral) literal()   {}
+func (*RegexLiteral) literal()    {}
+func (*ListLiteral) literal()     {}
+func (*TimeLiteral) literal()     {}
+func (*StringLiteral) literal()   {}
 
 // Source represents a source of data for a statement.
 type Source interface {
@@ -214,6 +240,19 @@ type SelectStatement struct {
 	// Expressions used for grouping the selection.
 	Dimensions Dimensions
 
+	// An expression evaluated on the aggregate values of each group.
+	// Groups for which it is not true are dropped.
+	Having Expr
+
+	// Fields to sort the groups by.
+	SortFields SortFields
+
+	// Maximum number of groups to be returned per window. Unlimited if zero.
+	Limit int
+
+	// Number of groups to skip per window.
+	Offset int
+
 	// if it's a query for raw data values (i.e. not an aggregate)
 	IsRawQuery bool
 
@@ -223,6 +262,35 @@ type SelectStatement struct {
 	Count int
 }
 
+// SortField represents a field to sort results by.
+type SortField struct {
+	// Expression of the aggregates or field aliases to sort by.
+	Expr Expr
+
+	// Sort order.
+	Ascending bool
+}
+
+// String returns a string representation of a sort field.
+func (field *SortField) String() string {
+	if field.Ascending {
+		return field.Expr.String() + " ASC"
+	}
+	return field.Expr.String() + " DESC"
+}
+
+// SortFields represents an ordered list of ORDER BY fields.
+type SortFields []*SortField
+
+// String returns a string representation of sort fields.
+func (a SortFields) String() string {
+	fields := make([]string, 0, len(a))
+	for _, field := range a {
+		fields = append(fields, field.String())
+	}
+	return strings.Join(fields, ", ")
+}
+
 // Dimension represents an expression that a select statement is grouped by.
 type Dimension struct {
 	Expr Expr
@@ -295,6 +363,13 @@ func (s *SelectStatement) ColumnNames() []string {
 	columnFields := Fields{}
 	for _, field := range s.Fields {
 		columnFields = append(columnFields, field)
+
+		// A top() or bottom() call has a column for each companion field.
+		if call, ok := field.Expr.(*Call); ok && isSelector(call) && len(call.Args) > 2 {
+			for _, arg := range call.Args[2:] {
+				columnFields = append(columnFields, &Field{Expr: arg})
+			}
+		}
 	}
 
 	columnNames := make([]string, len(columnFields))
@@ -353,6 +428,20 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" GROUP BY ")
 		_, _ = buf.WriteString(s.Dimensions.String())
 	}
+	if s.Having != nil {
+		_, _ = buf.WriteString(" HAVING ")
+		_, _ = buf.WriteString(s.Having.String())
+	}
+	if len(s.SortFields) > 0 {
+		_, _ = buf.WriteString(" ORDER BY ")
+		_, _ = buf.WriteString(s.SortFields.String())
+	}
+	if s.Limit > 0 {
+		_, _ = fmt.Fprintf(&buf, " LIMIT %d", s.Limit)
+	}
+	if s.Offset > 0 {
+		_, _ = fmt.Fprintf(&buf, " OFFSET %d", s.Offset)
+	}
 	return buf.String()
 }
 
@@ -369,9 +458,120 @@ func (s *SelectStatement) validate() error {
 		return err
 	}
 
+	if err := s.validateDimensions(); err != nil {
+		return err
+	}
+
+	if err := s.validateHaving(); err != nil {
+		return err
+	}
+
+	if err := s.validateSortFields(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *SelectStatement) validateDimensions() error {
+	var numTime int
+	for _, d := range s.Dimensions {
+		switch expr := d.Expr.(type) {
+		case *VarRef:
+		case *Call:
+			if expr.Name != "time" && expr.Name != "session" {
+				return fmt.Errorf("invalid dimension %s, only time() and session() functions allowed in GROUP BY", expr)
+			}
+			if expr.Filter != nil {
+				return fmt.Errorf("FILTER is only supported by aggregate functions, got %s()", expr.Name)
+			}
+			if numTime++; numTime > 1 {
+				return errors.New("multiple time dimensions not allowed")
+			}
+			if err := validateWindow(expr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid dimension %s, expected field, time() or session()", expr)
+		}
+	}
+	return nil
+}
+
+// validateWindow checks the arguments of a time() or session() dimension.
+func validateWindow(call *Call) error {
+	max := 1
+	if call.Name == "time" {
+		max = 2
+	}
+	if len(call.Args) < 1 || len(call.Args) > max {
+		return fmt.Errorf("invalid number of arguments for %s, expected 1 to %d, got %d", call.Name, max, len(call.Args))
+	}
+
+	var durations []time.Duration
+	for _, arg := range call.Args {
+		lit, ok := arg.(*DurationLiteral)
+		if !ok {
+			return fmt.Errorf("%s dimension must have duration arguments", call.Name)
+		} else if lit.Val <= 0 {
+			return fmt.Errorf("%s dimension must have positive durations, got %s", call.Name, lit)
+		} else if lit.Val < time.Second {
+			// Points are stamped in seconds, so shorter windows would share
+			// their timestamps.
+			return fmt.Errorf("%s dimension must have durations of at least 1s, got %s", call.Name, lit)
+		}
+		durations = append(durations, lit.Val)
+	}
+
+	if len(durations) == 2 && durations[1] > durations[0] {
+		return fmt.Errorf("time dimension must hop no further than its size, got %s", call)
+	}
 	return nil
 }
 
+// Window describes how the GROUP BY clause buckets events in time.
+type Window struct {
+	// Size is the length of each window, zero unless grouped by time().
+	Size time.Duration
+
+	// Every is the interval between window starts. It equals Size for
+	// tumbling windows and is shorter for hopping windows.
+	Every time.Duration
+
+	// Gap closes a session window once a group saw no events for that long.
+	Gap time.Duration
+}
+
+// GroupByWindow extracts the time window of the GROUP BY clause.
+// Returns the zero Window if there is no time dimension.
+func (s *SelectStatement) GroupByWindow() Window {
+	for _, d := range s.Dimensions {
+		call, ok := d.Expr.(*Call)
+		if !ok {
+			continue
+		}
+
+		switch call.Name {
+		case "time":
+			w := Window{Size: call.Args[0].(*DurationLiteral).Val}
+			w.Every = w.Size
+			if len(call.Args) == 2 {
+				w.Every = call.Args[1].(*DurationLiteral).Val
+			}
+			return w
+		case "session":
+			return Window{Gap: call.Args[0].(*DurationLiteral).Val}
+		}
+	}
+	return Window{}
+}
+
+// GroupByInterval extracts the time interval of the GROUP BY clause.
+// Returns zero if there is no time() dimension.
+func (s *SelectStatement) GroupByInterval() time.Duration {
+	return s.GroupByWindow().Size
+}
+
 func (s *SelectStatement) validateConditions() error {
 	expr := s.Condition
 	if expr == nil {
@@ -388,7 +588,25 @@ func validateCondition(expr Expr, op Token) error {
 
 	switch expr := expr.(type) {
 	case *Call:
-		return fmt.Errorf("invalid filter, unsupport function %s", expr.String())
+		fn, ok := lookupFunction(expr.Name)
+		if !ok {
+			return fmt.Errorf("invalid filter, unsupport function %s", expr.String())
+		}
+		if err := fn.validate(expr); err != nil {
+			return err
+		}
+		if isQuantifier(expr) {
+			switch op {
+			case ILLEGAL, AND, OR, ADD, SUB, MUL, DIV:
+				return fmt.Errorf("invalid filter, %s() can only be compared to a value", expr.Name)
+			}
+		}
+		for _, arg := range expr.Args {
+			if err := validateCondition(arg, ILLEGAL); err != nil {
+				return err
+			}
+		}
+		return nil
 	case *BinaryExpr:
 		err := validateCondition(expr.LHS, expr.Op)
 		if err != nil {
@@ -397,6 +615,11 @@ func validateCondition(expr Expr, op Token) error {
 		return validateCondition(expr.RHS, expr.Op)
 	case *ParenExpr:
 		return validateCondition(expr.Expr, ILLEGAL)
+	case *UnaryExpr:
+		if expr.Op == NOT {
+			return validateCondition(expr.Expr, ILLEGAL)
+		}
+		return validateCondition(expr.Expr, expr.Op)
 	case *RegexLiteral:
 		switch op {
 		case EQREGEX, NEQREGEX:
@@ -404,6 +627,20 @@ func validateCondition(expr Expr, op Token) error {
 		default:
 			return fmt.Errorf("invalid filter, unsupport op %s for regex", op.String())
 		}
+	case *IPLiteral:
+		switch op {
+		case EQ, NEQ, IN, NI, ILLEGAL:
+			return nil
+		default:
+			return fmt.Errorf("invalid filter, unsupport op %s for IP", op.String())
+		}
+	case *CIDRLiteral:
+		switch op {
+		case IN, NI:
+			return nil
+		default:
+			return fmt.Errorf("invalid filter, unsupport op %s for CIDR", op.String())
+		}
 	case *StringLiteral:
 		switch op {
 		case LT, LTE, GT, GTE, SUB, MUL, DIV, ADD:
@@ -423,25 +660,55 @@ func (s *SelectStatement) validateFields() error {
 		if c.foundInvalid {
 			return fmt.Errorf("invalid operator %s in SELECT field, only support +-*/", c.badToken)
 		}
-		switch expr := f.Expr.(type) {
+		// Parentheses do not change what a field may contain.
+		expr := f.Expr
+		for p, ok := expr.(*ParenExpr); ok; p, ok = expr.(*ParenExpr) {
+			expr = p.Expr
+		}
+		switch expr := expr.(type) {
 		case *BinaryExpr:
 			if err := expr.validate(); err != nil {
 				return err
 			}
-		case *ParenExpr:
 		case *Call:
+			if _, ok := lookupFunction(expr.Name); !ok {
+				break
+			}
+			if err := validateFieldExpr(expr); err != nil {
+				return err
+			}
+		case *UnaryExpr:
+			if err := validateFieldExpr(expr); err != nil {
+				return err
+			}
 		default:
-			return fmt.Errorf("invalid field %v in SELECT field, at least one function", expr)
+			return fmt.Errorf("invalid field %v in SELECT field, at least one function", f.Expr)
 		}
 	}
 	return nil
 }
 
+// validateFieldExpr checks that a field applying a scalar function or a
+// unary operator contains an aggregate and no raw fields.
+func validateFieldExpr(expr Expr) error {
+	v := binaryExprValidator{}
+	Walk(&v, expr)
+	if v.err != nil {
+		return v.err
+	} else if !v.calls {
+		return fmt.Errorf("invalid field %v in SELECT field, at least one function", expr)
+	} else if v.refs {
+		return errors.New("binary expressions cannot mix aggregates and raw fields")
+	}
+	return nil
+}
+
 // validSelectWithAggregate determines if a SELECT statement has the correct
 // combination of aggregate functions combined with selected fields and tags
 // Currently we don't have support for all aggregates, but aggregates that
 // can be combined with fields/tags are:
-//  TOP, BOTTOM, MAX, MIN, FIRST, LAST
+//
+//	TOP, BOTTOM, MAX, MIN, FIRST, LAST
 func (s *SelectStatement) validSelectWithAggregate() error {
 	calls := map[string]struct{}{}
 	numAggregates := 0
@@ -454,6 +721,20 @@ func (s *SelectStatement) validSelectWithAggregate() error {
 			numAggregates++
 		}
 	}
+	// TOP and BOTTOM select whole rows, so they must be the only field.
+	for _, f := range s.Fields {
+		for _, c := range walkFunctionCalls(f.Expr) {
+			if !isSelector(c) {
+				continue
+			}
+			if f.Expr != c {
+				return fmt.Errorf("selector function %s() cannot be used in an expression", c.Name)
+			} else if len(s.Fields) > 1 {
+				return fmt.Errorf("selector function %s() cannot be combined with other fields", c.Name)
+			}
+		}
+	}
+
 	// For TOP, BOTTOM, MAX, MIN, FIRST, LAST, PERCENTILE (selector functions) it is ok to ask for fields and tags
 	// but only if one function is specified.  Combining multiple functions and fields and tags is not currently supported
 	onlySelectors := true
@@ -483,34 +764,139 @@ func (s *SelectStatement) validSelectWithAggregate() error {
 }
 
 func (s *SelectStatement) validateAggregates() error {
-	for _, f := range s.Fields {
-		for _, expr := range walkFunctionCalls(f.Expr) {
-			if err := s.validSelectWithAggregate(); err != nil {
-				return err
-			}
-			if len(expr.Args) != 1 {
-				return fmt.Errorf("invalid number of arguments for %s, expected 1, got %d", expr.Name, len(expr.Args))
-			}
-			if expr.Name == "count" {
-				if _, ok := expr.Args[0].(*VarRef); !ok {
-					return fmt.Errorf("expected only field argument in count()")
-				}
-			}
-			switch fc := expr.Args[0].(type) {
-			case *VarRef:
-				// do nothing
-			case *BinaryExpr:
-				if err := fc.validateArgs(); err != nil {
-					return err
-				}
-			default:
-				return fmt.Errorf("expected field argument in %s()", expr.Name)
-			}
+	for _, expr := range s.FunctionCalls() {
+		if err := s.validSelectWithAggregate(); err != nil {
+			return err
+		}
+		agg, ok := lookupAggregate(expr.Name)
+		if !ok {
+			return fmt.Errorf("undefined aggregate function %s()", expr.Name)
+		}
+		if err := agg.validate(expr); err != nil {
+			return err
+		}
+		if err := validateCondition(expr.Filter, ILLEGAL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHaving checks that the HAVING clause refers only to aggregates
+// and to the aliases of fields.
+func (s *SelectStatement) validateHaving() error {
+	if s.Having == nil {
+		return nil
+	}
+	v := groupExprValidator{clause: "HAVING", desc: "HAVING condition"}
+	Walk(&v, s.havingCondition())
+	return v.err
+}
+
+// validateSortFields checks that the ORDER BY clause refers only to
+// aggregates and to the aliases of fields.
+func (s *SelectStatement) validateSortFields() error {
+	for _, expr := range s.sortExprs() {
+		v := groupExprValidator{clause: "ORDER BY", desc: "ORDER BY field"}
+		if Walk(&v, expr); v.err != nil {
+			return v.err
 		}
 	}
 	return nil
 }
 
+// groupExprValidator checks an expression evaluated against the aggregate
+// values of a group, once field aliases are resolved.
+type groupExprValidator struct {
+	clause string
+	desc   string
+	err    error
+}
+
+func (v *groupExprValidator) Visit(n Node) Visitor {
+	if v.err != nil {
+		return nil
+	}
+
+	switch n := n.(type) {
+	case *Call:
+		if fn, ok := lookupFunction(n.Name); ok {
+			v.err = fn.validate(n)
+			return v
+		}
+		if isSelector(n) {
+			v.err = fmt.Errorf("selector function %s() cannot be used in %s", n.Name, v.clause)
+		}
+		return nil
+	case *VarRef:
+		v.err = fmt.Errorf("invalid %s, %s is not an aggregate or a field alias", v.desc, n.Val)
+		return nil
+	}
+	return v
+}
+
+// havingCondition returns the HAVING clause with the aliases of fields
+// replaced by their expressions, so it can be evaluated against the
+// aggregate values of a group.
+func (s *SelectStatement) havingCondition() Expr {
+	if s.Having == nil {
+		return nil
+	}
+	return resolveAliases(s.Having, s.aliases())
+}
+
+// sortExprs returns the expressions of the ORDER BY clause with the aliases
+// of fields replaced by their expressions.
+func (s *SelectStatement) sortExprs() []Expr {
+	if len(s.SortFields) == 0 {
+		return nil
+	}
+	aliases := s.aliases()
+	exprs := make([]Expr, len(s.SortFields))
+	for i, field := range s.SortFields {
+		exprs[i] = resolveAliases(field.Expr, aliases)
+	}
+	return exprs
+}
+
+// aliases maps the alias of each field to its expression.
+func (s *SelectStatement) aliases() map[string]Expr {
+	aliases := make(map[string]Expr)
+	for _, f := range s.Fields {
+		if f.Alias != "" {
+			aliases[f.Alias] = f.Expr
+		}
+	}
+	return aliases
+}
+
+// resolveAliases replaces the references to aliases outside of aggregate
+// calls. Aggregate calls are kept as is, as they key the aggregate values.
+func resolveAliases(expr Expr, aliases map[string]Expr) Expr {
+	switch expr := expr.(type) {
+	case *VarRef:
+		if e, ok := aliases[expr.Val]; ok {
+			return e
+		}
+	case *BinaryExpr:
+		return &BinaryExpr{Op: expr.Op, LHS: resolveAliases(expr.LHS, aliases), RHS: resolveAliases(expr.RHS, aliases)}
+	case *ParenExpr:
+		return &ParenExpr{Expr: resolveAliases(expr.Expr, aliases)}
+	case *UnaryExpr:
+		return &UnaryExpr{Op: expr.Op, Expr: resolveAliases(expr.Expr, aliases)}
+	case *Call:
+		if _, ok := lookupFunction(expr.Name); !ok {
+			return expr
+		}
+		args := make([]Expr, len(expr.Args))
+		for i, arg := range expr.Args {
+			args[i] = resolveAliases(arg, aliases)
+		}
+		return &Call{Name: expr.Name, Args: args}
+	}
+	return expr
+}
+
 // NamesInWhere returns the field and tag names (idents) referenced in the where clause
 func (s *SelectStatement) NamesInWhere() []string {
 	var a []string
@@ -551,6 +937,8 @@ func walkNames(exp Expr) []string {
 		return ret
 	case *ParenExpr:
 		return walkNames(expr.Expr)
+	case *UnaryExpr:
+		return walkNames(expr.Expr)
 	}
 
 	return nil
@@ -578,6 +966,8 @@ func walkRefs(exp Expr) []VarRef {
 		return ret
 	case *ParenExpr:
 		return walkRefs(expr.Expr)
+	case *UnaryExpr:
+		return walkRefs(expr.Expr)
 	}
 
 	return nil
@@ -589,6 +979,10 @@ func (s *SelectStatement) FunctionCalls() []*Call {
 	for _, f := range s.Fields {
 		a = append(a, walkFunctionCalls(f.Expr)...)
 	}
+	a = append(a, walkFunctionCalls(s.Having)...)
+	for _, field := range s.SortFields {
+		a = append(a, walkFunctionCalls(field.Expr)...)
+	}
 	return a
 }
 
@@ -607,7 +1001,15 @@ func walkFunctionCalls(exp Expr) []*Call {
 	case *VarRef:
 		return nil
 	case *Call:
-		return []*Call{expr}
+		if _, ok := lookupFunction(expr.Name); !ok {
+			return []*Call{expr}
+		}
+		// Look for aggregates in the arguments of scalar functions.
+		var ret []*Call
+		for _, arg := range expr.Args {
+			ret = append(ret, walkFunctionCalls(arg)...)
+		}
+		return ret
 	case *BinaryExpr:
 		var ret []*Call
 		ret = append(ret, walkFunctionCalls(expr.LHS)...)
@@ -615,6 +1017,8 @@ func walkFunctionCalls(exp Expr) []*Call {
 		return ret
 	case *ParenExpr:
 		return walkFunctionCalls(expr.Expr)
+	case *UnaryExpr:
+		return walkFunctionCalls(expr.Expr)
 	}
 
 	return nil
@@ -728,6 +1132,9 @@ func (f *Field) Name() string {
 	case *ParenExpr:
 		f := Field{Expr: expr.Expr}
 		return f.Name()
+	case *UnaryExpr:
+		f := Field{Expr: expr.Expr}
+		return f.Name()
 	case *VarRef:
 		return expr.Val
 	}
@@ -754,7 +1161,30 @@ type VarRef struct {
 
 // String returns a string representation of the variable reference.
 func (r *VarRef) String() string {
-	buf := bytes.NewBufferString(r.Val)
+	if len(r.Segments) == 0 {
+		return QuoteIdent(r.Val)
+	}
+	return joinSegments(r.Segments, QuoteIdent)
+}
+
+// joinSegments returns the name of a field from its segments, joining keys
+// with dots and appending array indexes. Keys are quoted with quote if it
+// is not nil.
+func joinSegments(segments []string, quote func(...string) string) string {
+	var buf bytes.Buffer
+	for i, s := range segments {
+		if strings.HasPrefix(s, "[") {
+			_, _ = buf.WriteString(s)
+			continue
+		}
+		if i > 0 {
+			_ = buf.WriteByte('.')
+		}
+		if quote != nil {
+			s = quote(s)
+		}
+		_, _ = buf.WriteString(s)
+	}
 	return buf.String()
 }
 
@@ -772,12 +1202,12 @@ func (a VarRefs) Strings() []string {
 
 // Call represents a function call.
 type Call struct {
-	Name          string
-	Args          []Expr  // must hava not funcCall expr
-	result        float64 // must be float64
-	groupdResults map[string]float64
-	First         bool
-	Count         int
+	Name string
+	Args []Expr // must hava not funcCall expr
+
+	// Filter restricts the events folded by an aggregate, as in
+	// count(x) FILTER (WHERE status >= 500).
+	Filter Expr
 }
 
 // String returns a string representation of the call.
@@ -789,6 +1219,9 @@ func (c *Call) String() string {
 	}
 
 	// Write function name and args.
+	if c.Filter != nil {
+		return fmt.Sprintf("%s(%s) FILTER (WHERE %s)", c.Name, strings.Join(str, ", "), c.Filter)
+	}
 	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(str, ", "))
 }
 
@@ -839,7 +1272,17 @@ type NumberLiteral struct {
 }
 
 // String returns a string representation of the literal.
-func (l *NumberLiteral) String() string { return strconv.FormatFloat(l.Val, 'f', 3, 64) }
+func (l *NumberLiteral) String() string { return formatNumber(l.Val) }
+
+// formatNumber formats v in full, keeping a decimal point or an exponent so
+// that it scans back as a number rather than an integer.
+func formatNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
 
 // IntegerLiteral represents an integer literal.
 type IntegerLiteral struct {
@@ -893,17 +1336,47 @@ func (s *ListLiteral) String() string {
 		}
 		switch v := tagKey.(type) {
 		case string:
-			_, _ = buf.WriteString(QuoteIdent(v))
+			_, _ = buf.WriteString(QuoteString(v))
 		case float64:
-			_, _ = buf.WriteString((fmt.Sprintf("%f", v)))
+			_, _ = buf.WriteString(formatNumber(v))
 		case int64:
 			_, _ = buf.WriteString((fmt.Sprintf("%d", v)))
+		case bool:
+			_, _ = buf.WriteString(strconv.FormatBool(v))
+		case net.IP:
+			_, _ = buf.WriteString((&IPLiteral{Val: v}).String())
+		case *net.IPNet:
+			_, _ = buf.WriteString((&CIDRLiteral{Val: v}).String())
 		}
 	}
 	_, _ = buf.WriteString("]")
 	return buf.String()
 }
 
+// DurationLiteral represents a duration literal.
+type DurationLiteral struct {
+	Val time.Duration
+}
+
+// String returns a string representation of the literal.
+func (l *DurationLiteral) String() string { return FormatDuration(l.Val) }
+
+// IPLiteral represents an IPv4 or IPv6 address literal.
+type IPLiteral struct {
+	Val net.IP
+}
+
+// String returns a string representation of the literal.
+func (l *IPLiteral) String() string { return "IP(" + QuoteString(l.Val.String()) + ")" }
+
+// CIDRLiteral represents an IPv4 or IPv6 network literal.
+type CIDRLiteral struct {
+	Val *net.IPNet
+}
+
+// String returns a string representation of the literal.
+func (l *CIDRLiteral) String() string { return "CIDR(" + QuoteString(l.Val.String()) + ")" }
+
 // StringLiteral represents a string literal.
 type StringLiteral struct {
 	Val string
@@ -912,6 +1385,33 @@ type StringLiteral struct {
 // String returns a string representation of the literal.
 func (l *StringLiteral) String() string { return QuoteString(l.Val) }
 
+// TimeLiteral represents a point-in-time literal, written as an RFC3339
+// string compared with a time.
+type TimeLiteral struct {
+	Val time.Time
+
+	// Raw is the string the literal was written as, if any. Values that
+	// are not timestamps are compared with it as a string.
+	Raw string
+}
+
+// String returns a string representation of the literal.
+func (l *TimeLiteral) String() string {
+	if l.Raw != "" {
+		return QuoteString(l.Raw)
+	}
+	return QuoteString(l.Val.Format(time.RFC3339Nano))
+}
+
+// BoundParameter represents a $name placeholder, replaced by a literal
+// when the statement is bound with Bind.
+type BoundParameter struct {
+	Name string
+}
+
+// String returns a string representation of the bound parameter.
+func (bp *BoundParameter) String() string { return "$" + bp.Name }
+
 // nilLiteral represents a nil literal.
 // This is not available to the query language itself. It's only used internally.
 type nilLiteral struct{}
@@ -919,6 +1419,27 @@ type nilLiteral struct{}
 // String returns a string representation of the literal.
 func (l *nilLiteral) String() string { return `nil` }
 
+// NullLiteral represents the NULL of an IS [NOT] NULL test.
+type NullLiteral struct{}
+
+// String returns a string representation of the literal.
+func (l *NullLiteral) String() string { return `NULL` }
+
+// UnaryExpr represents an operation on a single expression, either a
+// logical NOT or a negation.
+type UnaryExpr struct {
+	Op   Token
+	Expr Expr
+}
+
+// String returns a string representation of the unary expression.
+func (e *UnaryExpr) String() string {
+	if e.Op == NOT {
+		return "NOT " + e.Expr.String()
+	}
+	return e.Op.String() + e.Expr.String()
+}
+
 // BinaryExpr represents an operation between two expressions.
 type BinaryExpr struct {
 	Op  Token
@@ -943,14 +1464,17 @@ func (e *BinaryExpr) validate() error {
 }
 
 func (e *BinaryExpr) validateArgs() error {
+	return validateArgExpr(e)
+}
+
+// validateArgExpr checks that an aggregate argument contains no aggregate calls.
+func validateArgExpr(e Expr) error {
 	v := binaryExprValidator{}
 	Walk(&v, e)
 	if v.err != nil {
 		return v.err
 	} else if v.calls {
 		return errors.New("argument binary expressions cannot mix function")
-	} else if !v.refs {
-		return errors.New("argument binary expressions at least one key")
 	}
 	return nil
 }
@@ -968,6 +1492,15 @@ func (v *binaryExprValidator) Visit(n Node) Visitor {
 
 	switch n := n.(type) {
 	case *Call:
+		// Scalar functions are evaluated per document like their arguments.
+		if isQuantifier(n) {
+			v.err = fmt.Errorf("%s() can only be used in a condition", n.Name)
+			return nil
+		} else if fn, ok := lookupFunction(n.Name); ok {
+			v.err = fn.validate(n)
+			return v
+		}
+
 		v.calls = true
 		for _, expr := range n.Args {
 			switch e := expr.(type) {
@@ -1065,10 +1598,23 @@ func Walk(v Visitor, node Node) {
 	case *ParenExpr:
 		Walk(v, n.Expr)
 
+	case *UnaryExpr:
+		Walk(v, n.Expr)
+
 	case *SelectStatement:
 		Walk(v, n.Fields)
 		Walk(v, n.Sources)
 		Walk(v, n.Condition)
+		Walk(v, n.Having)
+		Walk(v, n.SortFields)
+
+	case SortFields:
+		for _, s := range n {
+			Walk(v, s)
+		}
+
+	case *SortField:
+		Walk(v, n.Expr)
 
 	case Sources:
 		for _, s := range n {
@@ -1160,3 +1706,119 @@ type Measurement struct {
 func (m *Measurement) String() string {
 	return m.Database
 }
+
+// ParseDuration parses a time duration from a string.
+// This is needed instead of time.ParseDuration because this will support
+// the full syntax that InfluxQL supports for specifying durations
+// including weeks and days.
+func ParseDuration(s string) (time.Duration, error) {
+	// Return an error if the string is blank or one character
+	if len(s) < 2 {
+		return 0, ErrInvalidDuration
+	}
+
+	// Split string into individual runes.
+	a := split(s)
+
+	// Start with a zero duration.
+	var d int64
+	i := 0
+
+	// Check for a negative.
+	isNegative := false
+	if a[i] == '-' {
+		isNegative = true
+		i++
+	}
+
+	var measure int64
+
+	// Parsing loop.
+	for i < len(a) {
+		// Find the number portion.
+		start := i
+		for ; i < len(a) && isDigit(a[i]); i++ {
+			// Scan for the digits.
+		}
+
+		// Check if we reached the end of the string prematurely.
+		if i >= len(a) || i == start {
+			return 0, ErrInvalidDuration
+		}
+
+		// Parse the numeric part.
+		n, err := strconv.ParseInt(string(a[start:i]), 10, 64)
+		if err != nil {
+			return 0, ErrInvalidDuration
+		}
+		measure = n
+
+		// Extract the unit of measure.
+		// If the last two characters are "ms" then parse as milliseconds.
+		// Otherwise just use the last character as the unit of measure.
+		switch a[i] {
+		case 'n':
+			if i+1 < len(a) && a[i+1] == 's' {
+				d += measure
+				i += 2
+				continue
+			}
+			return 0, ErrInvalidDuration
+		case 'u', 'µ':
+			d += measure * int64(time.Microsecond)
+		case 'm':
+			if i+1 < len(a) && a[i+1] == 's' {
+				d += measure * int64(time.Millisecond)
+				i += 2
+				continue
+			}
+			d += measure * int64(time.Minute)
+		case 's':
+			d += measure * int64(time.Second)
+		case 'h':
+			d += measure * int64(time.Hour)
+		case 'd':
+			d += measure * int64(24*time.Hour)
+		case 'w':
+			d += measure * int64(7*24*time.Hour)
+		default:
+			return 0, ErrInvalidDuration
+		}
+		i++
+	}
+
+	// Check to see if we overflowed a duration
+	if d < 0 && !isNegative {
+		return 0, fmt.Errorf("overflowed duration %s: choose a smaller duration", s)
+	}
+
+	if isNegative {
+		d = -d
+	}
+	return time.Duration(d), nil
+}
+
+// FormatDuration formats a duration to a string.
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	} else if d%(7*24*time.Hour) == 0 {
+		return fmt.Sprintf("%dw", d/(7*24*time.Hour))
+	} else if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	} else if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	} else if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	} else if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	} else if d%time.Millisecond == 0 {
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	} else if d%time.Microsecond == 0 {
+		// Although we accept both "u" and "µ" when reading microsecond durations,
+		// we output with "u", which can be represented in 1 byte,
+		// instead of "µ", which requires 2 bytes.
+		return fmt.Sprintf("%du", d/time.Microsecond)
+	}
+	return fmt.Sprintf("%dns", d)
+}
@@ -285,3 +285,50 @@ func (o Valuer) Value(key string) (v interface{}, ok bool) {
 	v, ok = o[key]
 	return
 }
+
+// Ensure calls are formatted with their filter.
+func TestCall_String(t *testing.T) {
+	for i, s := range []string{
+		`sum(x)`,
+		`count(host) FILTER (WHERE status >= 500)`,
+		`distinct_count(host) FILTER (WHERE lower(proto) = 'tcp')`,
+		`count(x) FILTER (WHERE ts > now() - 15m AND ts < '2016-12-14T16:00:00+08:00')`,
+	} {
+		if got := MustParseExpr(s).String(); got != s {
+			t.Errorf("%d. string mismatch:\n  exp=%s\n  got=%s", i, s, got)
+		}
+	}
+}
+
+// Ensure field references are quoted where needed so statements round-trip.
+func TestVarRef_String(t *testing.T) {
+	for i, s := range []string{
+		`tcp.in_bytes`,
+		"`user-agent`",
+		"@metadata.beat",
+		"@metadata.`with.dot`.`with space`.`select`",
+		"dns.answers[*].data",
+		"tags[0] = 'a`b'",
+		"`a\\`b` IN ['x', 'y']",
+	} {
+		if got := MustParseExpr(s).String(); got != s {
+			t.Errorf("%d. string mismatch:\n  exp=%s\n  got=%s", i, s, got)
+		}
+	}
+
+	for i, s := range []string{
+		"SELECT count(`user-agent`) AS `total count` FROM packetbeat WHERE `http.request`.`user-agent` =~ /curl/ GROUP BY `@metadata`.beat",
+		"SELECT sum(`bytes-in` + `bytes-out`) FROM packetbeat WHERE host IN ['a', 'b c'] GROUP BY time(1m)",
+	} {
+		stmt, err := jepl.ParseStatement(s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, s, err)
+		}
+		other, err := jepl.ParseStatement(stmt.String())
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error parsing %q: %s", i, s, stmt.String(), err)
+		} else if !reflect.DeepEqual(stmt, other) {
+			t.Errorf("%d. %q: round trip mismatch:\n  exp=%s\n  got=%s", i, s, stmt, other)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package jepl
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"time"
+)
+
+// Bind returns a copy of stmt with every $name bound parameter replaced by
+// the literal of params[name]. Strings, booleans, integers, floats, times,
+// durations, IPs and networks are supported. The operand of IN and NI must
+// be a slice or a network, and the operand of =~ and !~ a regex or a string
+// holding one.
+//
+// A *BindError is returned if a parameter is missing or has an unsupported
+// type, and an error if the bound statement is invalid.
+func Bind(stmt Statement, params map[string]interface{}) (Statement, error) {
+	s, ok := stmt.(*SelectStatement)
+	if !ok {
+		return nil, &UnsupportedStatementError{Stmt: stmt}
+	}
+
+	clone := s.Clone()
+	b := &binder{params: params}
+	for _, f := range clone.Fields {
+		f.Expr = b.bind(f.Expr, ILLEGAL)
+	}
+	for _, d := range clone.Dimensions {
+		d.Expr = b.bind(d.Expr, ILLEGAL)
+	}
+	clone.Condition = b.bind(clone.Condition, ILLEGAL)
+	clone.Having = b.bind(clone.Having, ILLEGAL)
+	for _, f := range clone.SortFields {
+		f.Expr = b.bind(f.Expr, ILLEGAL)
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if err := clone.validate(); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// BindError represents a bound parameter that is missing or whose value
+// cannot be bound.
+type BindError struct {
+	// Name of the parameter, without the leading $.
+	Name    string
+	Message string
+}
+
+// Error returns the string representation of the error.
+func (e *BindError) Error() string { return e.Message }
+
+// newBindError returns a BindError for p.
+func newBindError(p *BoundParameter, format string, args ...interface{}) *BindError {
+	return &BindError{Name: p.Name, Message: fmt.Sprintf(format, args...)}
+}
+
+// firstParameter returns the first bound parameter of s, or nil if every
+// parameter is bound.
+func (s *SelectStatement) firstParameter() *BoundParameter {
+	var exprs []Expr
+	for _, f := range s.Fields {
+		exprs = append(exprs, f.Expr)
+	}
+	for _, d := range s.Dimensions {
+		exprs = append(exprs, d.Expr)
+	}
+	exprs = append(exprs, s.Condition, s.Having)
+	for _, f := range s.SortFields {
+		exprs = append(exprs, f.Expr)
+	}
+	for _, expr := range exprs {
+		if p := boundParameter(expr); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// boundParameter returns the first bound parameter of expr, or nil.
+func boundParameter(expr Expr) *BoundParameter {
+	switch expr := expr.(type) {
+	case *BoundParameter:
+		return expr
+	case *BinaryExpr:
+		if p := boundParameter(expr.LHS); p != nil {
+			return p
+		}
+		return boundParameter(expr.RHS)
+	case *Call:
+		for _, arg := range expr.Args {
+			if p := boundParameter(arg); p != nil {
+				return p
+			}
+		}
+		return boundParameter(expr.Filter)
+	case *ParenExpr:
+		return boundParameter(expr.Expr)
+	case *UnaryExpr:
+		return boundParameter(expr.Expr)
+	}
+	return nil
+}
+
+// binder replaces the bound parameters of an expression in place.
+// The first error is kept and stops further binding.
+type binder struct {
+	params map[string]interface{}
+	err    error
+}
+
+// bind binds the parameters of expr, an operand of op.
+func (b *binder) bind(expr Expr, op Token) Expr {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		lhsOp := expr.Op
+		if IsListOp(lhsOp) || IsRegexOp(lhsOp) {
+			lhsOp = ILLEGAL
+		}
+		expr.LHS = b.bind(expr.LHS, lhsOp)
+		if bounds, ok := expr.RHS.(*BinaryExpr); ok && expr.Op == BETWEEN {
+			bounds.LHS = b.bind(bounds.LHS, BETWEEN)
+			bounds.RHS = b.bind(bounds.RHS, BETWEEN)
+			return expr
+		}
+		expr.RHS = b.bind(expr.RHS, expr.Op)
+	case *Call:
+		for i, arg := range expr.Args {
+			expr.Args[i] = b.bind(arg, ILLEGAL)
+		}
+		expr.Filter = b.bind(expr.Filter, ILLEGAL)
+	case *ParenExpr:
+		expr.Expr = b.bind(expr.Expr, ILLEGAL)
+	case *UnaryExpr:
+		expr.Expr = b.bind(expr.Expr, ILLEGAL)
+	case *BoundParameter:
+		if b.err != nil {
+			return expr
+		}
+		lit, err := b.literal(expr, op)
+		if err != nil {
+			b.err = err
+			return expr
+		}
+		return lit
+	}
+	return expr
+}
+
+// literal returns the literal bound to p as an operand of op.
+func (b *binder) literal(p *BoundParameter, op Token) (Expr, error) {
+	v, ok := b.params[p.Name]
+	if !ok {
+		return nil, newBindError(p, "missing parameter %s", p)
+	}
+
+	switch {
+	case IsListOp(op):
+		if ipnet, ok := v.(*net.IPNet); ok {
+			return &CIDRLiteral{Val: ipnet}, nil
+		}
+		return listLiteral(p, v)
+	case IsRegexOp(op):
+		switch v := v.(type) {
+		case *regexp.Regexp:
+			return &RegexLiteral{Val: v}, nil
+		case string:
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, newBindError(p, "invalid regex for parameter %s: %s", p, err)
+			}
+			return &RegexLiteral{Val: re}, nil
+		}
+		return nil, newBindError(p, "parameter %s must be a regex, got %T", p, v)
+	}
+
+	lit, ok := scalarLiteral(v)
+	if !ok {
+		return nil, newBindError(p, "unsupported type %T for parameter %s", v, p)
+	}
+	switch op {
+	case EQ, NEQ, LT, LTE, GT, GTE, BETWEEN:
+		// Match ParseExpr, which reads RFC3339 strings compared here as times.
+		return timeLiteral(lit), nil
+	}
+	return lit, nil
+}
+
+// scalarLiteral returns the literal of a single value.
+func scalarLiteral(v interface{}) (Expr, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return &TimeLiteral{Val: v}, true
+	case time.Duration:
+		return &DurationLiteral{Val: v}, true
+	case net.IP:
+		return &IPLiteral{Val: v}, true
+	case *net.IPNet:
+		return &CIDRLiteral{Val: v}, true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return &StringLiteral{Val: rv.String()}, true
+	case reflect.Bool:
+		return &BooleanLiteral{Val: rv.Bool()}, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &IntegerLiteral{Val: rv.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, false
+		}
+		return &IntegerLiteral{Val: int64(rv.Uint())}, true
+	case reflect.Float32, reflect.Float64:
+		return &NumberLiteral{Val: rv.Float()}, true
+	}
+	return nil, false
+}
+
+// listLiteral returns the list literal of a slice or array bound to p.
+func listLiteral(p *BoundParameter, v interface{}) (Expr, error) {
+	rv := reflect.ValueOf(v)
+	if _, ok := v.(net.IP); ok || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+		return nil, newBindError(p, "parameter %s must be a list, got %T", p, v)
+	}
+
+	vals := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		elem := rv.Index(i).Interface()
+		lit, ok := scalarLiteral(elem)
+		if !ok {
+			return nil, newBindError(p, "unsupported type %T in list parameter %s", elem, p)
+		}
+		switch lit := lit.(type) {
+		case *StringLiteral:
+			vals = append(vals, lit.Val)
+		case *BooleanLiteral:
+			vals = append(vals, lit.Val)
+		case *IntegerLiteral:
+			vals = append(vals, lit.Val)
+		case *NumberLiteral:
+			vals = append(vals, lit.Val)
+		case *IPLiteral:
+			vals = append(vals, lit.Val)
+		case *CIDRLiteral:
+			vals = append(vals, lit.Val)
+		default:
+			return nil, newBindError(p, "unsupported type %T in list parameter %s", elem, p)
+		}
+	}
+	return &ListLiteral{Vals: vals}, nil
+}
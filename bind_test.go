@@ -0,0 +1,162 @@
+package jepl_test
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure parameters are replaced by literals of their type.
+func TestBind(t *testing.T) {
+	for i, tt := range []struct {
+		s      string
+		params map[string]interface{}
+		out    string
+		err    string
+		name   string // of the parameter in a *BindError
+	}{
+		{
+			s:      `select count(x) from foo where host IN $hosts AND bytes > $min`,
+			params: map[string]interface{}{"hosts": []string{"web1", "web2"}, "min": 1024},
+			out:    `SELECT count(x) FROM foo WHERE host IN ['web1', 'web2'] AND bytes > 1024`,
+		},
+		{
+			s:      `select count(x) from foo where port NI $ports AND ratio < $ratio AND up = $up`,
+			params: map[string]interface{}{"ports": []interface{}{80, uint16(443), true}, "ratio": 0.5, "up": false},
+			out:    `SELECT count(x) FROM foo WHERE port NI [80, 443, true] AND ratio < 0.5 AND up = false`,
+		},
+		{
+			s:      `select count(x) from foo where url =~ $pattern AND agent !~ $bot`,
+			params: map[string]interface{}{"pattern": `^/api/`, "bot": regexp.MustCompile(`bot`)},
+			out:    `SELECT count(x) FROM foo WHERE url =~ /^\/api\// AND agent !~ /bot/`,
+		},
+		{
+			s:      `select count(x) from foo where ip IN $net OR ip = $ip`,
+			params: map[string]interface{}{"net": mustParseCIDR("10.0.0.0/8"), "ip": net.ParseIP("::1")},
+			out:    `SELECT count(x) FROM foo WHERE ip IN CIDR('10.0.0.0/8') OR ip = IP('::1')`,
+		},
+		{
+			s:      `select sum(bytes) filter (where host = $host) from foo where ts BETWEEN $from AND now() - $ago`,
+			params: map[string]interface{}{"host": "web1", "from": "2016-12-14T16:00:00Z", "ago": time.Minute},
+			out:    `SELECT sum(bytes) FILTER (WHERE host = 'web1') FROM foo WHERE ts BETWEEN '2016-12-14T16:00:00Z' AND now() - 1m`,
+		},
+		{
+			s:      `select count(*) from foo where name != '2024-01-01T00:00:00.000Z' AND y = $y`,
+			params: map[string]interface{}{"y": 1},
+			out:    `SELECT count() FROM foo WHERE name != '2024-01-01T00:00:00.000Z' AND y = 1`,
+		},
+
+		{s: `select count(*) from foo where host = $host`, err: `missing parameter $host`, name: "host"},
+		{
+			s:      `select count(*) FILTER (WHERE code = $code) from foo where host = $host`,
+			params: map[string]interface{}{"host": "web1"},
+			err:    `missing parameter $code`,
+			name:   "code",
+		},
+		{
+			s:      `select count(*) from foo where host IN $hosts`,
+			params: map[string]interface{}{"hosts": "web1"},
+			err:    `parameter $hosts must be a list, got string`,
+			name:   "hosts",
+		},
+		{
+			s:      `select count(*) from foo where host IN $hosts`,
+			params: map[string]interface{}{"hosts": []interface{}{"web1", nil}},
+			err:    `unsupported type <nil> in list parameter $hosts`,
+			name:   "hosts",
+		},
+		{
+			s:      `select count(*) from foo where url =~ $pattern`,
+			params: map[string]interface{}{"pattern": 1},
+			err:    `parameter $pattern must be a regex, got int`,
+			name:   "pattern",
+		},
+		{
+			s:      `select count(*) from foo where url =~ $pattern`,
+			params: map[string]interface{}{"pattern": `(`},
+			err:    "invalid regex for parameter $pattern: error parsing regexp: missing closing ): `(`",
+			name:   "pattern",
+		},
+		{
+			s:      `select count(*) from foo where host = $host`,
+			params: map[string]interface{}{"host": struct{}{}},
+			err:    `unsupported type struct {} for parameter $host`,
+			name:   "host",
+		},
+		{
+			s:      `select count(*) from foo where host > $host`,
+			params: map[string]interface{}{"host": "web1"},
+			err:    `invalid filter, unsupport op > for string`,
+		},
+	} {
+		stmt, err := jepl.ParseStatement(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %s: %s", i, tt.s, err)
+		}
+		bound, err := jepl.Bind(stmt, tt.params)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		} else if e, ok := err.(*jepl.BindError); ok != (tt.name != "") || (ok && e.Name != tt.name) {
+			t.Errorf("%d. %s: unexpected bind error %#v", i, tt.s, err)
+		} else if err == nil && bound.String() != tt.out {
+			t.Errorf("%d. %s: unexpected statement:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.out, bound)
+		} else if stmt.String() == tt.out {
+			t.Errorf("%d. %s: statement was modified", i, tt.s)
+		}
+	}
+}
+
+// Ensure a bound statement is evaluated and unbound ones are rejected.
+func TestBind_Query(t *testing.T) {
+	sql := `select count(*) from foo where host IN $hosts AND code >= $code`
+	if _, err := jepl.NewQuery(sql); errstring(err) != `missing parameter $hosts` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if e, ok := err.(*jepl.BindError); !ok || e.Name != "hosts" {
+		t.Fatalf("unexpected error type %T", err)
+	}
+	if _, err := jepl.NewQuery(`select sum(x) from foo group by time(1m) order by sum(x) FILTER (WHERE y = $y)`); errstring(err) != `missing parameter $y` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stmt, err := jepl.ParseStatement(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound, err := jepl.Bind(stmt, map[string]interface{}{"hosts": []string{"web1", "web2"}, "code": 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := jepl.NewSelectQuery(bound.(*jepl.SelectStatement))
+	for _, doc := range []string{
+		`{"host": "web1", "code": 500}`,
+		`{"host": "web2", "code": 503}`,
+		`{"host": "web2", "code": 200}`,
+		`{"host": "web1' OR '1' = '1", "code": 500}`,
+	} {
+		if err := q.Push([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ps := q.Flush().Get("").Points; len(ps) != 1 || ps[0].Metric != 2 {
+		t.Fatalf("unexpected points: %v", ps)
+	}
+	// Binding keeps time literals comparing with other strings as strings.
+	stmt, err = jepl.ParseStatement(`select count(*) from foo where name != '2024-01-01T00:00:00Z' AND y = $y`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bound, err = jepl.Bind(stmt, map[string]interface{}{"y": 1}); err != nil {
+		t.Fatal(err)
+	}
+	q = jepl.NewSelectQuery(bound.(*jepl.SelectStatement))
+	if err := q.Push([]byte(`{"name": "foo", "y": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if ps := q.Flush().Get("").Points; len(ps) != 1 || ps[0].Metric != 1 {
+		t.Fatalf("unexpected points: %v", ps)
+	}
+}
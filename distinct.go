@@ -0,0 +1,158 @@
+package jepl
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// exactDistinctLimit is the number of distinct values counted exactly
+// before switching to a HyperLogLog sketch.
+const exactDistinctLimit = 1000
+
+// hllPrecision is the number of hash bits selecting a HyperLogLog register.
+// 2^14 registers give a standard error of about 0.8%.
+const hllPrecision = 14
+
+func init() {
+	RegisterAggregate("distinct_count", &Aggregate{
+		Args: []ArgType{FieldArg},
+		New:  func([]Expr) Accumulator { return &distinctAccumulator{hashes: make(map[uint64]struct{})} },
+	})
+}
+
+// distinctAccumulator counts the distinct values of a field. Value hashes
+// are kept exactly up to exactDistinctLimit, then folded into a HyperLogLog
+// sketch so memory stays bounded.
+type distinctAccumulator struct {
+	hashes map[uint64]struct{}
+	hll    *hyperLogLog
+}
+
+func (a *distinctAccumulator) Add(ts int64, args []interface{}) {
+	if x, ok := hashValue(args[0]); ok {
+		a.add(x)
+	}
+}
+
+func (a *distinctAccumulator) add(x uint64) {
+	if a.hll != nil {
+		a.hll.add(x)
+		return
+	}
+	a.hashes[x] = struct{}{}
+	if len(a.hashes) > exactDistinctLimit {
+		a.hll = &hyperLogLog{}
+		for x := range a.hashes {
+			a.hll.add(x)
+		}
+		a.hashes = nil
+	}
+}
+
+func (a *distinctAccumulator) Merge(other Accumulator) {
+	o := other.(*distinctAccumulator)
+	for x := range o.hashes {
+		a.add(x)
+	}
+	if o.hll == nil {
+		return
+	}
+	if a.hll == nil {
+		a.hll = &hyperLogLog{}
+		for x := range a.hashes {
+			a.hll.add(x)
+		}
+		a.hashes = nil
+	}
+	a.hll.merge(o.hll)
+}
+
+func (a *distinctAccumulator) Result() interface{} {
+	if a.hll != nil {
+		return math.Round(a.hll.count())
+	}
+	return float64(len(a.hashes))
+}
+
+// hashValue returns a 64-bit hash of a document value. Values of different
+// types hash differently, so 1 and '1' are distinct. Missing values are not hashed.
+func hashValue(v interface{}) (uint64, bool) {
+	h := fnv.New64a()
+	switch v := v.(type) {
+	case string:
+		h.Write([]byte{'s'})
+		h.Write([]byte(v))
+	case float64:
+		var b [9]byte
+		b[0] = 'f'
+		binary.LittleEndian.PutUint64(b[1:], math.Float64bits(v))
+		h.Write(b[:])
+	case bool:
+		if v {
+			h.Write([]byte{'t'})
+		} else {
+			h.Write([]byte{'b'})
+		}
+	default:
+		return 0, false
+	}
+
+	// FNV spreads short inputs poorly over the high bits, which select the
+	// HyperLogLog register, so finish with the splitmix64 mixer.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x, true
+}
+
+// hyperLogLog estimates the number of distinct hashes added to it.
+// See Flajolet et al., "HyperLogLog: the analysis of a near-optimal
+// cardinality estimation algorithm".
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// add records hash x. The top bits select a register, which keeps the
+// longest run of leading zeros seen in the remaining bits.
+func (h *hyperLogLog) add(x uint64) {
+	i := x >> (64 - hllPrecision)
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	if rho := uint8(bits.LeadingZeros64(w)) + 1; rho > h.registers[i] {
+		h.registers[i] = rho
+	}
+}
+
+// merge folds the registers of other into h.
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// count returns the estimated number of distinct hashes, using linear
+// counting while the estimate is small.
+func (h *hyperLogLog) count() float64 {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return e
+}
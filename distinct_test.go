@@ -0,0 +1,67 @@
+package jepl_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure distinct values are counted exactly for small inputs.
+func TestDistinctCount_Exact(t *testing.T) {
+	docs := []string{
+		`{"dst": "a", "src": "10.0.0.1"}`,
+		`{"dst": "a", "src": "10.0.0.2"}`,
+		`{"dst": "a", "src": "10.0.0.1"}`,
+		`{"dst": "a", "src": 1}`,
+		`{"dst": "a", "src": "1"}`,
+		`{"dst": "a"}`,
+		`{"dst": "b", "src": true}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp map[string]float64
+	}{
+		{s: `select count(DISTINCT src) from foo`, exp: map[string]float64{"": 5}},
+		{s: `select distinct_count(src), count(src) from foo group by dst`, exp: map[string]float64{`dst='a'`: 4, `dst='b'`: 1}},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		for k, exp := range tt.exp {
+			if got := res.Get(k).Points[0].Metric; got != exp {
+				t.Errorf("%d. %q: %s: exp=%v got=%v", i, tt.s, k, exp, got)
+			}
+		}
+	}
+}
+
+// Ensure large distinct counts are estimated within bounds, including
+// across merged sessions.
+func TestDistinctCount_HyperLogLog(t *testing.T) {
+	for i, n := range []int{500, 20000} {
+		var docs []string
+		for j := 0; j < 2*n; j++ {
+			// Every value twice, in two sessions bridged by the last event.
+			docs = append(docs, fmt.Sprintf(`{"@timestamp": %d, "ip": "10.%d.%d.%d"}`, j%2*100, j%n>>16, j%n>>8&255, j%n&255))
+		}
+		docs = append(docs, `{"@timestamp": 50, "ip": "10.0.0.0"}`)
+
+		for _, s := range []string{
+			`select count(DISTINCT ip) from foo`,
+			`select count(DISTINCT ip) from foo group by session(1m)`,
+		} {
+			res, err := jepl.ExecSQL(s, docs)
+			if err != nil {
+				t.Fatalf("%d. %q: unexpected error: %s", i, s, err)
+			}
+			got := res.Get("").Points[0].Metric
+			if e := math.Abs(got-float64(n)) / float64(n); e > 0.02 {
+				t.Errorf("%d. %q: exp~%d got=%v", i, s, n, got)
+			}
+		}
+	}
+}
@@ -3,8 +3,11 @@ package jepl
 import (
 	"fmt"
 	"github.com/buger/jsonparser"
+	"math"
+	"net"
 	"reflect"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -16,134 +19,382 @@ type point struct {
 	TS     int64
 }
 
-func (s *SelectStatement) evalMetric() Points {
-	ps := []point{}
-	for _, f := range s.Fields {
-		ps = append(ps, point{Eval(f.Expr, nil).(float64), time.Now().Unix()})
+// evalRows evaluates the fields of the statement with the aggregate values
+// of a group and window. A selector yields a row per selected value.
+func (s *SelectStatement) evalRows(vals map[*Call]interface{}, ts int64, valuer Valuer) []Row {
+	if c, ok := s.Fields[0].Expr.(*Call); ok && isSelector(c) {
+		sel, _ := vals[c].([][]interface{})
+		rows := make([]Row, len(sel))
+		for i, values := range sel {
+			rows[i] = Row{TS: ts, Values: values}
+		}
+		return rows
+	}
+
+	row := Row{TS: ts, Values: make([]interface{}, len(s.Fields))}
+	for i, f := range s.Fields {
+		row.Values[i] = eval(f.Expr, nil, valuer, vals)
 	}
-	return ps
+	return []Row{row}
 }
 
-//EvalSQL return metric points map[filter]metric
+// EvalSQL return metric points map[filter]metric.
+// It panics if sql cannot be parsed or is not a select statement;
+// use ExecSQL to get those back as errors.
 func EvalSQL(sql string, docs []string) map[string]Points {
-	stmt, err := ParseStatement(sql)
+	q, err := NewQuery(sql)
 	if err != nil {
 		panic(err)
 	}
-	selectStmt, ok := stmt.(*SelectStatement)
-	if !ok {
-		panic("Not support stmt")
+	for _, doc := range docs {
+		_ = q.Push([]byte(doc))
 	}
 
 	pm := make(map[string]Points)
+	for _, series := range q.Flush() {
+		pm[condString(q.stmt.groupCondition(series.Tags))] = series.Points
+	}
+	return pm
+}
 
-	selectStmts := make(map[string]*SelectStatement)
-	selectStmts[selectStmt.Condition.String()] = selectStmt
-
-	if len(selectStmt.Dimensions) > 0 {
-		selectStmts = selectStmt.FlatStatByGroup(docs)
+// ExecSQL evaluates sql against docs and returns a series per group.
+// A *ParseError, *UnsupportedStatementError or *BindError is returned if
+// sql cannot be evaluated at all. Documents failing evaluation are skipped and reported
+// through an *EvalErrors alongside the series of the remaining documents.
+func ExecSQL(sql string, docs []string) (Result, error) {
+	q, err := NewQuery(sql)
+	if err != nil {
+		return nil, err
 	}
 
-	for k, st := range selectStmts {
-		for _, doc := range docs {
-			switch res := Eval(st.Condition, &doc).(type) {
-			case bool:
-				if res == true {
-					st.evalFunctionCalls(&doc)
-				}
-			default:
-				fmt.Println("Select Where Condition parse error")
-			}
+	errs := &EvalErrors{}
+	for i, doc := range docs {
+		switch err := q.Push([]byte(doc)).(type) {
+		case nil:
+		case *EvalError:
+			errs.add(err)
+		default:
+			// Other errors, such as ErrLateEvent, are reported like
+			// documents that could not be evaluated.
+			errs.add(&EvalError{Doc: i, Message: err.Error(), Err: err})
 		}
-		ms := st.evalMetric()
-		pm[k] = ms
 	}
-	return pm
+
+	res := q.Flush()
+	if errs.Count > 0 {
+		return res, errs
+	}
+	return res, nil
+}
+
+// match reports whether js satisfies the WHERE clause of the statement.
+// A condition of unknown truth, such as a comparison with a missing field,
+// is not satisfied.
+func (s *SelectStatement) match(js *string, valuer Valuer) (bool, *EvalError) {
+	if s.Condition == nil {
+		return true, nil
+	}
+	switch res := EvalWithValuer(s.Condition, js, valuer).(type) {
+	case bool:
+		return res, nil
+	case nil:
+		return false, nil
+	default:
+		return false, &EvalError{Message: fmt.Sprintf("condition %s evaluated to %v, expected bool", s.Condition, res)}
+	}
+}
+
+// UnsupportedStatementError is returned when a statement cannot be evaluated.
+type UnsupportedStatementError struct {
+	Stmt Statement
+}
+
+// Error returns the string representation of the error.
+func (e *UnsupportedStatementError) Error() string {
+	return fmt.Sprintf("unsupported statement %s", e.Stmt)
+}
+
+// EvalError represents a document that could not be evaluated.
+type EvalError struct {
+	// Position of the document in the input.
+	Doc     int
+	Message string
+
+	// Err is the underlying error, if any, e.g. ErrMissingTime.
+	Err error
+}
+
+// Error returns the string representation of the error.
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%s at doc %d", e.Message, e.Doc)
+}
+
+// Unwrap returns the underlying error.
+func (e *EvalError) Unwrap() error { return e.Err }
+
+// maxEvalErrors is the number of document errors kept by EvalErrors.
+const maxEvalErrors = 10
+
+// EvalErrors counts the documents that failed evaluation.
+// Only the first few errors are kept.
+type EvalErrors struct {
+	Count  int
+	Errors []*EvalError
+}
+
+func (e *EvalErrors) add(err *EvalError) {
+	e.Count++
+	if len(e.Errors) < maxEvalErrors {
+		e.Errors = append(e.Errors, err)
+	}
+}
+
+// Error returns the string representation of the error.
+func (e *EvalErrors) Error() string {
+	if e.Count == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%d docs failed to evaluate, first: %s", e.Count, e.Errors[0])
 }
 
 // Eval evaluates expr against a map.
+// Scalar function calls are applied to their arguments; aggregate calls
+// evaluate to nil.
 func Eval(expr Expr, js *string) interface{} {
+	return eval(expr, js, nil, nil)
+}
+
+// EvalWithValuer evaluates expr like Eval, taking the value of now() from
+// valuer if it has one, e.g. a *NowValuer. Otherwise now() is the current
+// time.
+func EvalWithValuer(expr Expr, js *string, valuer Valuer) interface{} {
+	return eval(expr, js, valuer, nil)
+}
+
+// eval evaluates expr against a map, resolving aggregate calls from aggs.
+func eval(expr Expr, js *string, valuer Valuer, aggs map[*Call]interface{}) interface{} {
 	if expr == nil {
 		return nil
 	}
 
 	switch expr := expr.(type) {
 	case *Call:
-		var ret interface{}
-
-		if expr.Name == "count" {
-			ret = float64(expr.Count)
-		} else {
-			ret = expr.result
-			if expr.Name == "avg" {
-				if expr.Count > 0 {
-					ret = expr.result / float64(expr.Count)
-				}
+		if expr.Name == "now" && valuer != nil {
+			if v, ok := valuer.Value("now()"); ok {
+				return v
 			}
 		}
-
-		expr.result = 0.0
-		expr.First = true
-		expr.Count = 0
-
-		return ret
+		if fn, ok := lookupFunction(expr.Name); ok {
+			args := make([]interface{}, len(expr.Args))
+			for i, arg := range expr.Args {
+				args[i] = eval(arg, js, valuer, aggs)
+			}
+			return fn.Call(args)
+		}
+		return aggs[expr]
 	case *BinaryExpr:
-		return evalBinaryExpr(expr, js)
+		return evalBinaryExpr(expr, js, valuer, aggs)
+	case *UnaryExpr:
+		return evalUnaryExpr(expr, js, valuer, aggs)
+	case *NullLiteral:
+		return nil
 	case *BooleanLiteral:
 		return expr.Val
+	case *DurationLiteral:
+		return expr.Val
 	case *ListLiteral:
 		return expr.Vals
 	case *IntegerLiteral:
 		return expr.Val
+	case *IPLiteral:
+		return expr.Val
+	case *CIDRLiteral:
+		return expr.Val
+	case *TimeLiteral:
+		return expr.Val
 	case *NumberLiteral:
 		return expr.Val
 	case *ParenExpr:
-		return Eval(expr.Expr, js)
+		return eval(expr.Expr, js, valuer, aggs)
 	case *RegexLiteral:
 		return expr.Val
 	case *StringLiteral:
 		return expr.Val
 	case *VarRef:
-		if val, dt, _, err := jsonparser.Get([]byte(*js), expr.Segments...); err == nil {
-			switch dt {
-			case jsonparser.Number:
-				v, _ := jsonparser.ParseFloat(val)
-				return v
-
-			case jsonparser.String:
-				v, _ := jsonparser.ParseString(val)
-				return v
+		if js == nil {
+			return nil
+		}
+		return fieldValue([]byte(*js), expr.Segments)
+	default:
+		return nil
+	}
 
-			case jsonparser.Boolean:
-				v, _ := jsonparser.ParseBoolean(val)
-				return v
+}
 
-			default:
-				return nil
+// fieldValue returns the value of the field at segments in a document.
+// A wildcard segment, "[*]", yields an array of the values at the rest of
+// the path in each element, flattening arrays.
+func fieldValue(data []byte, segments []string) interface{} {
+	for i, seg := range segments {
+		if seg != "[*]" {
+			continue
+		}
+		vals := []interface{}{}
+		_, err := jsonparser.ArrayEach(data, func(val []byte, dt jsonparser.ValueType, _ int, _ error) {
+			var v interface{}
+			if i+1 == len(segments) {
+				v = jsonValue(val, dt)
+			} else if dt == jsonparser.Object || dt == jsonparser.Array {
+				v = fieldValue(val, segments[i+1:])
 			}
-		} else {
-			// fmt.Println(err, expr.Segments)
+			if a, ok := v.([]interface{}); ok {
+				vals = append(vals, a...)
+			} else {
+				vals = append(vals, v)
+			}
+		}, segments[:i]...)
+		if err != nil {
 			return nil
 		}
+		return vals
+	}
+
+	val, dt, _, err := jsonparser.Get(data, segments...)
+	if err != nil {
+		return nil
+	}
+	return jsonValue(val, dt)
+}
+
+// jsonValue converts a JSON value to a float64, string, bool or an array
+// of them. Objects and null are nil.
+func jsonValue(val []byte, dt jsonparser.ValueType) interface{} {
+	switch dt {
+	case jsonparser.Number:
+		v, _ := jsonparser.ParseFloat(val)
+		return v
+	case jsonparser.String:
+		v, _ := jsonparser.ParseString(val)
+		return v
+	case jsonparser.Boolean:
+		v, _ := jsonparser.ParseBoolean(val)
+		return v
+	case jsonparser.Array:
+		vals := []interface{}{}
+		_, _ = jsonparser.ArrayEach(val, func(val []byte, dt jsonparser.ValueType, _ int, _ error) {
+			vals = append(vals, jsonValue(val, dt))
+		})
+		return vals
 	default:
 		return nil
 	}
+}
+
+// evalUnaryExpr evaluates NOT and negation. Both are unknown, nil, for an
+// operand of the wrong type or a missing one.
+func evalUnaryExpr(expr *UnaryExpr, js *string, valuer Valuer, aggs map[*Call]interface{}) interface{} {
+	v := eval(expr.Expr, js, valuer, aggs)
+	switch expr.Op {
+	case NOT:
+		if b, ok := v.(bool); ok {
+			return !b
+		}
+	case SUB:
+		switch v := v.(type) {
+		case float64:
+			return -v
+		case int64:
+			return -v
+		}
+	}
+	return nil
+}
+
+// evalBinaryExpr evaluates expr with three-valued logic: an operation on a
+// missing value is unknown, nil, rather than false. AND and OR follow SQL,
+// so false AND unknown is false and true OR unknown is true. IS [NOT] NULL
+// tests for a missing value and is never unknown.
+func evalBinaryExpr(expr *BinaryExpr, js *string, valuer Valuer, aggs map[*Call]interface{}) interface{} {
+	if expr.Op == BETWEEN {
+		return evalBetween(expr, js, valuer, aggs)
+	}
+
+	lhs := eval(expr.LHS, js, valuer, aggs)
+	rhs := eval(expr.RHS, js, valuer, aggs)
 
+	if isQuantifier(expr.LHS) || isQuantifier(expr.RHS) {
+		return quantify(expr.LHS, lhs, func(lhs interface{}) interface{} {
+			return quantify(expr.RHS, rhs, func(rhs interface{}) interface{} {
+				return evalOperands(expr, lhs, rhs)
+			})
+		})
+	}
+	return evalOperands(expr, lhs, rhs)
 }
 
-func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
-	lhs := Eval(expr.LHS, js)
-	rhs := Eval(expr.RHS, js)
+// evalOperands applies the operator of expr to its evaluated operands. A
+// time literal tested for equality with a string that is not a timestamp
+// is compared as the string it was written as.
+func evalOperands(expr *BinaryExpr, lhs, rhs interface{}) interface{} {
+	switch expr.Op {
+	case EQ, NEQ:
+		lhs, rhs = timeText(expr.LHS, lhs, rhs), timeText(expr.RHS, rhs, lhs)
+	}
+	return evalBinary(expr.Op, lhs, rhs)
+}
+
+// timeText returns the raw string of the time literal expr, evaluated to
+// v, if other is a string that is not a timestamp. Otherwise it returns v.
+func timeText(expr Expr, v, other interface{}) interface{} {
+	lit, ok := expr.(*TimeLiteral)
+	if !ok || lit.Raw == "" {
+		return v
+	}
+	if s, ok := other.(string); ok {
+		if _, ok := toTime(s); !ok {
+			return lit.Raw
+		}
+	}
+	return v
+}
+
+// evalBinary applies a binary operator, other than BETWEEN, to the
+// evaluated operands.
+func evalBinary(op Token, lhs, rhs interface{}) interface{} {
+	switch op {
+	case AND, OR:
+		return evalLogical(op, lhs, rhs)
+	case IS:
+		return lhs == nil
+	case ISNOT:
+		return lhs != nil
+	}
+	if lhs == nil || rhs == nil {
+		return nil
+	}
+
+	switch op {
+	case LIKE, ILIKE, STARTSWITH, ENDSWITH, CONTAINS:
+		return evalStringPredicate(op, lhs, rhs)
+	}
+	if isAddress(lhs) || isAddress(rhs) {
+		return evalAddress(op, lhs, rhs)
+	}
+	if isTime(lhs) || isTime(rhs) {
+		return evalTime(op, lhs, rhs)
+	}
 
 	// Evaluate if both sides are simple types.
 	switch lhs := lhs.(type) {
 	case bool:
+		switch op {
+		case IN:
+			return inList(lhs, rhs)
+		case NI:
+			return !inList(lhs, rhs)
+		}
 		rhs, ok := rhs.(bool)
-		switch expr.Op {
-		case AND:
-			return ok && (lhs && rhs)
-		case OR:
-			return ok && (lhs || rhs)
+		switch op {
 		case EQ:
 			return ok && (lhs == rhs)
 		case NEQ:
@@ -159,7 +410,7 @@ func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
 			}
 		}
 
-		switch expr.Op {
+		switch op {
 		case IN:
 			return inList(lhs, rhs)
 		case NI:
@@ -192,10 +443,8 @@ func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
 			}
 			return lhs * rhsf
 		case DIV:
-			if !ok {
+			if !ok || rhsf == 0 {
 				return nil
-			} else if rhs == 0 {
-				return float64(0)
 			}
 			return lhs / rhsf
 		}
@@ -205,7 +454,7 @@ func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
 		if ok {
 			lhs := float64(lhs)
 			rhs := rhsf
-			switch expr.Op {
+			switch op {
 			case EQ:
 				return lhs == rhs
 			case NEQ:
@@ -226,13 +475,13 @@ func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
 				return lhs * rhs
 			case DIV:
 				if rhs == 0 {
-					return float64(0)
+					return nil
 				}
 				return lhs / rhs
 			}
 		} else {
 			rhsi, ok := rhs.(int64)
-			switch expr.Op {
+			switch op {
 			case IN:
 				return inList(lhs, rhs)
 			case NI:
@@ -265,16 +514,14 @@ func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
 				}
 				return lhs * rhsi
 			case DIV:
-				if !ok {
+				if !ok || rhsi == 0 {
 					return nil
-				} else if rhs == 0 {
-					return float64(0)
 				}
 				return lhs / rhsi
 			}
 		}
 	case string:
-		switch expr.Op {
+		switch op {
 		case IN:
 			return inList(lhs, rhs)
 		case NI:
@@ -296,72 +543,306 @@ func evalBinaryExpr(expr *BinaryExpr, js *string) interface{} {
 	return nil
 }
 
-// EvalBool evaluates expr and returns true if result is a boolean true.
-// Otherwise returns false.
-func EvalBool(expr Expr, js *string) bool {
-	v, _ := Eval(expr, js).(bool)
-	return v
+// evalBetween evaluates "x BETWEEN lower AND upper", inclusive of both
+// bounds. Numbers and strings can be compared; x is not between bounds of
+// another type.
+func evalBetween(expr *BinaryExpr, js *string, valuer Valuer, aggs map[*Call]interface{}) interface{} {
+	bounds := expr.RHS.(*BinaryExpr)
+	v := eval(expr.LHS, js, valuer, aggs)
+	lower := eval(bounds.LHS, js, valuer, aggs)
+	upper := eval(bounds.RHS, js, valuer, aggs)
+	return quantify(expr.LHS, v, func(v interface{}) interface{} {
+		return between(v, lower, upper)
+	})
 }
 
-// FunctionCalls returns the Call objects from the query
-func (s *SelectStatement) evalFunctionCalls(js *string) {
-	for _, f := range s.Fields {
-		evalFC(f.Expr, js)
+// between returns true if v is within the inclusive bounds.
+func between(v, lower, upper interface{}) interface{} {
+	if v == nil || lower == nil || upper == nil {
+		return nil
 	}
+	if isTime(v) || isTime(lower) || isTime(upper) {
+		t, ok := toTime(v)
+		lt, lok := toTime(lower)
+		ut, uok := toTime(upper)
+		return ok && lok && uok && !t.Before(lt) && !t.After(ut)
+	}
+	if typeRank(v) != typeRank(lower) || typeRank(v) != typeRank(upper) {
+		return false
+	}
+	return compareValues(lower, v) <= 0 && compareValues(v, upper) <= 0
 }
 
-func evalFC(expr Expr, js *string) {
-	switch expr := expr.(type) {
-	case *Call:
-		expr.Count++
-
-		switch expr.Name {
-		case "sum", "avg":
-			switch res := Eval(expr.Args[0], js).(type) {
-			case int64:
-				expr.result += float64(res)
-			case float64:
-				expr.result += res
-			}
-		case "max":
-			var thisret float64
-			switch res := Eval(expr.Args[0], js).(type) {
-			case int64:
-				thisret = float64(res)
-			case float64:
-				thisret = res
-			}
-			if expr.First {
-				expr.result = thisret
-				expr.First = false
-			} else {
-				if thisret > expr.result {
-					expr.result = thisret
-				}
-			}
+// isQuantifier returns true if expr is an any() or all() call.
+func isQuantifier(expr Expr) bool {
+	if c, ok := expr.(*Call); ok {
+		return c.Name == "any" || c.Name == "all"
+	}
+	return false
+}
 
-		case "min":
-			var thisret float64
-			switch res := Eval(expr.Args[0], js).(type) {
-			case int64:
-				thisret = float64(res)
-			case float64:
-				thisret = res
-			}
-			if expr.First {
-				expr.result = thisret
-				expr.First = false
-			} else {
-				if thisret < expr.result {
-					expr.result = thisret
+// quantify evaluates f for each element of v when expr is any() or all(),
+// and otherwise for v itself. any() is true if f is true for some element
+// and all() if it is true for every element, so any() of an empty array is
+// false and all() is true. As with OR and AND, elements for which f is
+// unknown make the result unknown unless another element decides it.
+func quantify(expr Expr, v interface{}, f func(interface{}) interface{}) interface{} {
+	if !isQuantifier(expr) {
+		return f(v)
+	}
+	elems, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	op, result := OR, interface{}(false)
+	if expr.(*Call).Name == "all" {
+		op, result = AND, true
+	}
+	for _, e := range elems {
+		result = evalLogical(op, result, f(e))
+		if b, ok := result.(bool); ok && b == (op == OR) {
+			break
+		}
+	}
+	return result
+}
+
+// evalStringPredicate evaluates the string operators. They are false for
+// operands other than strings.
+func evalStringPredicate(op Token, lhs, rhs interface{}) bool {
+	s, ok := lhs.(string)
+	if !ok {
+		return false
+	}
+	t, ok := rhs.(string)
+	if !ok {
+		return false
+	}
+
+	switch op {
+	case LIKE:
+		return like(s, t)
+	case ILIKE:
+		return like(strings.ToLower(s), strings.ToLower(t))
+	case STARTSWITH:
+		return strings.HasPrefix(s, t)
+	case ENDSWITH:
+		return strings.HasSuffix(s, t)
+	case CONTAINS:
+		return strings.Contains(s, t)
+	}
+	return false
+}
+
+// like matches s against a LIKE pattern, where % matches any sequence of
+// characters and _ a single character. A backslash matches the following
+// character literally.
+func like(s, pattern string) bool {
+	sr, pr := []rune(s), []rune(pattern)
+
+	// Match greedily, backtracking to the last % on a mismatch.
+	var si, pi int
+	star, next := -1, 0
+	for si < len(sr) {
+		if pi < len(pr) {
+			switch c := pr[pi]; {
+			case c == '%':
+				star, next = pi, si
+				pi++
+				continue
+			case c == '\\' && pi+1 < len(pr):
+				if pr[pi+1] == sr[si] {
+					si, pi = si+1, pi+2
+					continue
 				}
+			case c == '_' || c == sr[si]:
+				si, pi = si+1, pi+1
+				continue
 			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		si, pi = next, star+1
+	}
+	for pi < len(pr) && pr[pi] == '%' {
+		pi++
+	}
+	return pi == len(pr)
+}
+
+// isAddress returns true if v is an IP address or network value.
+func isAddress(v interface{}) bool {
+	switch v.(type) {
+	case net.IP, *net.IPNet:
+		return true
+	}
+	return false
+}
+
+// toIP returns v as an IP address, parsing it if v is a string. It returns
+// nil if v is not an address.
+func toIP(v interface{}) net.IP {
+	switch v := v.(type) {
+	case net.IP:
+		return v
+	case string:
+		return net.ParseIP(v)
+	}
+	return nil
+}
+
+// matchAddress returns true if v is the IP address addr, or lies within
+// the network addr. Addresses are compared by value, so '::ffff:10.0.0.1'
+// equals '10.0.0.1' and IPv6 addresses match in any notation.
+func matchAddress(v interface{}, addr interface{}) bool {
+	ip := toIP(v)
+	if ip == nil {
+		return false
+	}
+	switch addr := addr.(type) {
+	case net.IP:
+		return ip.Equal(addr)
+	case *net.IPNet:
+		return addr.Contains(ip)
+	}
+	return false
+}
+
+// evalAddress evaluates a comparison where at least one side is an IP
+// address or network. Strings are parsed as addresses; a value that is not
+// an address is never equal to one.
+func evalAddress(op Token, lhs, rhs interface{}) interface{} {
+	if (op == EQ || op == NEQ) && !isAddress(rhs) {
+		lhs, rhs = rhs, lhs
+	}
+
+	var ok bool
+	if isAddress(rhs) {
+		ok = matchAddress(lhs, rhs)
+	} else {
+		ok = inList(lhs, rhs)
+	}
+
+	switch op {
+	case EQ, IN:
+		return ok
+	case NEQ:
+		return !ok
+	case NI:
+		return !ok
+	}
+	return nil
+}
 
+// isTime returns true if v is a time.
+func isTime(v interface{}) bool {
+	_, ok := v.(time.Time)
+	return ok
+}
+
+// epochMillisThreshold is the smallest number taken as epoch milliseconds
+// rather than seconds. As seconds it is in the year 5138.
+const epochMillisThreshold = 1e11
+
+// toTime returns v as a time. Numbers are epoch seconds, or milliseconds
+// from epochMillisThreshold, and strings are RFC3339 timestamps.
+func toTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case float64:
+		return epochTime(v), true
+	case int64:
+		return epochTime(float64(v)), true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// epochTime returns the time of epoch seconds or milliseconds.
+func epochTime(v float64) time.Time {
+	unit := float64(time.Second)
+	if math.Abs(v) >= epochMillisThreshold {
+		unit = float64(time.Millisecond)
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(0, int64(sec*unit)+int64(frac*unit)).UTC()
+}
+
+// evalTime evaluates an operator where at least one side is a time. A
+// duration may be added to or subtracted from a time, and the other side
+// of a comparison is converted with toTime; a value that is not a time
+// compares false.
+func evalTime(op Token, lhs, rhs interface{}) interface{} {
+	switch op {
+	case ADD, SUB:
+		t, ok := lhs.(time.Time)
+		d, dok := rhs.(time.Duration)
+		if op == ADD && !ok {
+			t, ok = rhs.(time.Time)
+			d, dok = lhs.(time.Duration)
+		}
+		if !ok || !dok {
+			return nil
+		} else if op == SUB {
+			d = -d
+		}
+		return t.Add(d)
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		l, lok := toTime(lhs)
+		r, rok := toTime(rhs)
+		if !lok || !rok {
+			return false
+		}
+		switch op {
+		case EQ:
+			return l.Equal(r)
+		case NEQ:
+			return !l.Equal(r)
+		case LT:
+			return l.Before(r)
+		case LTE:
+			return !l.After(r)
+		case GT:
+			return l.After(r)
+		case GTE:
+			return !l.Before(r)
 		}
-	case *BinaryExpr:
-		evalFC(expr.LHS, js)
-		evalFC(expr.RHS, js)
 	}
+	return nil
+}
+
+// evalLogical evaluates AND and OR, where operands other than booleans are
+// unknown.
+func evalLogical(op Token, lhs, rhs interface{}) interface{} {
+	l, lok := lhs.(bool)
+	r, rok := rhs.(bool)
+	switch op {
+	case AND:
+		if (lok && !l) || (rok && !r) {
+			return false
+		}
+	case OR:
+		if (lok && l) || (rok && r) {
+			return true
+		}
+	}
+	if lok && rok {
+		return op == AND
+	}
+	return nil
+}
+
+// EvalBool evaluates expr and returns true if result is a boolean true.
+// Otherwise returns false.
+func EvalBool(expr Expr, js *string) bool {
+	v, _ := Eval(expr, js).(bool)
+	return v
 }
 
 func inList(val interface{}, array interface{}) (exists bool) {
@@ -372,7 +853,19 @@ func inList(val interface{}, array interface{}) (exists bool) {
 		s := reflect.ValueOf(array)
 
 		for i := 0; i < s.Len(); i++ {
-			if reflect.DeepEqual(val, s.Index(i).Interface()) == true {
+			elem := s.Index(i).Interface()
+			if isAddress(elem) {
+				if matchAddress(val, elem) {
+					exists = true
+					return
+				}
+			} else if x, ok := number(val); ok {
+				// Integers in the list match the float64 numbers of JSON.
+				if y, ok := number(elem); ok && x == y {
+					exists = true
+					return
+				}
+			} else if reflect.DeepEqual(val, elem) == true {
 				exists = true
 				return
 			}
@@ -6,6 +6,7 @@ import (
 	"github.com/chenyoufu/jepl"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestTypeValid(t *testing.T) {
@@ -43,6 +44,12 @@ func TestTypeInvalid(t *testing.T) {
 		{s: `select max(tcp.in_pkts) from packetbeat where uid <= "xxx"`, err: `invalid filter, unsupport op <= for string`},
 		{s: `select max(tcp.in_pkts) from packetbeat where uid = "xxx" AND xx > "yyy"`, err: `invalid filter, unsupport op > for string`},
 		{s: `select max(tcp.in_pkts) from packetbeat where uid = 5 * "xxx" + "xxx"`, err: `invalid filter, unsupport op * for string`},
+		{s: `select max(tcp.in_pkts) from packetbeat where tcp.src_ip > IP('10.0.0.1')`, err: `invalid filter, unsupport op > for IP`},
+		{s: `select max(tcp.in_pkts) from packetbeat where tcp.src_ip = CIDR('10.0.0.0/8')`, err: `invalid filter, unsupport op = for CIDR`},
+		{s: `select max(tcp.in_pkts) from packetbeat where ANY(tags)`, err: `invalid filter, any() can only be compared to a value`},
+		{s: `select max(tcp.in_pkts) from packetbeat where ALL(tags) + 1 > 2`, err: `invalid filter, all() can only be compared to a value`},
+		{s: `select max(tcp.in_pkts) from packetbeat where ANY(lower(tags)) = 'a'`, err: `expected only field argument in any()`},
+		{s: `select max(any(tcp.in_pkts)) from packetbeat`, err: `any() can only be used in a condition`},
 	}
 	for i, test := range tests {
 		_, err := jepl.ParseStatement(test.s)
@@ -97,7 +104,85 @@ func TestEvalQuery1(t *testing.T) {
 	got := pm["uid = 1"][0].Metric
 	expect := float64(120)
 	if got != expect {
-		t.Errorf("exp=%d\n  got=%d\n\n", expect, got)
+		t.Errorf("exp=%v\n  got=%v\n\n", expect, got)
+	}
+}
+
+func TestExecSQL_Errors(t *testing.T) {
+	docs := []string{
+		`{"uid": 1, "in_bytes": 10}`,
+		`{"in_bytes": 20}`,
+		`{"uid": 1, "in_bytes": 30}`,
+		`{"in_bytes": 40}`,
+	}
+
+	for i, tt := range []struct {
+		s     string
+		err   string
+		count int
+	}{
+		{s: `select sum(in_bytes) from packetbeat where`, err: `found EOF, expected identifier, string, number, bool at line 1, char 44`},
+		{s: `select sum(in_bytes) from packetbeat where uid = 1`},
+		{s: `select sum(in_bytes) from packetbeat where uid`, err: `2 docs failed to evaluate, first: condition uid evaluated to 1, expected bool at doc 0`, count: 2},
+		{s: `select sum(in_bytes) from packetbeat where in_bytes > 10`},
+	} {
+		_, err := jepl.ExecSQL(tt.s, docs)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		}
+		switch err := err.(type) {
+		case *jepl.ParseError:
+		case *jepl.EvalErrors:
+			if err.Count != tt.count {
+				t.Errorf("%d. %q: failed count mismatch: exp=%d got=%d", i, tt.s, tt.count, err.Count)
+			}
+		case nil:
+		default:
+			t.Errorf("%d. %q: unexpected error type %T", i, tt.s, err)
+		}
+	}
+}
+
+func TestExecSQL_SkipsFailedDocs(t *testing.T) {
+	docs := []string{
+		`{"uid": true, "in_bytes": 10}`,
+		`{"uid": 1, "in_bytes": 20}`,
+		`{"uid": true, "in_bytes": 30}`,
+	}
+	res, err := jepl.ExecSQL("select sum(in_bytes) from packetbeat where uid", docs)
+	if _, ok := err.(*jepl.EvalErrors); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := res.Get("").Points[0].Metric; got != 40 {
+		t.Errorf("exp=40 got=%v", got)
+	}
+}
+
+// Ensure division by zero yields no value instead of panicking.
+func TestExecSQL_DivideByZero(t *testing.T) {
+	docs := []string{
+		`{"x": 2, "y": 0}`,
+		`{"x": 4, "y": 2}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp float64
+	}{
+		{s: `select sum(x) from foo where 1 / 0 = 1`, exp: 0},
+		{s: `select sum(x) from foo where x = 0x10 / 0`, exp: 0},
+		{s: `select sum(x) from foo where x / y = 2`, exp: 4},
+		{s: `select sum(x) from foo where 1.5 / 0 = 1`, exp: 0},
+		{s: `select sum(x / y) from foo`, exp: 2},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Errorf("%d. %q: unexpected error: %s", i, tt.s, err)
+			continue
+		}
+		if got := res.Get("").Points[0].Metric; got != tt.exp {
+			t.Errorf("%d. %q: exp=%v got=%v", i, tt.s, tt.exp, got)
+		}
 	}
 }
 
@@ -195,3 +280,313 @@ func BenchmarkEvalFunctionCalls(b *testing.B) {
 		jepl.EvalSQL(s, js)
 	}
 }
+
+// Ensure conditions follow three-valued logic, where operations on missing
+// values are unknown.
+func TestEval_ThreeValued(t *testing.T) {
+	js := `{"a": 1, "s": "x", "t": true, "f": false, "n": null}`
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: `a = 1`, out: true},
+		{in: `m = 1`, out: nil},
+		{in: `m != 1`, out: nil},
+		{in: `1 = m`, out: nil},
+		{in: `n = 1`, out: nil},
+		{in: `NOT a = 1`, out: false},
+		{in: `NOT m = 1`, out: nil},
+		{in: `NOT s`, out: nil},
+		{in: `NOT NOT t`, out: true},
+		{in: `f AND m = 1`, out: false},
+		{in: `t AND m = 1`, out: nil},
+		{in: `t OR m = 1`, out: true},
+		{in: `f OR m = 1`, out: nil},
+		{in: `t AND NOT f`, out: true},
+		{in: `m IS NULL`, out: true},
+		{in: `n IS NULL`, out: true},
+		{in: `a IS NULL`, out: false},
+		{in: `m IS NOT NULL OR a IS NOT NULL`, out: true},
+		{in: `exists(a)`, out: true},
+		{in: `exists(n)`, out: false},
+		{in: `NOT exists(m)`, out: true},
+		{in: `-a`, out: float64(-1)},
+		{in: `-a * 2 = -2`, out: true},
+		{in: `-(a + 1)`, out: float64(-2)},
+		{in: `-m`, out: nil},
+		{in: `-s`, out: nil},
+		{in: `m + 1`, out: nil},
+		{in: `a-1`, out: float64(0)},
+		{in: `a IN [-1.5, 1.0]`, out: true},
+	} {
+		if out := jepl.Eval(MustParseExpr(tt.in), &js); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+
+	// Without a document, field references have no value.
+	if out := jepl.Eval(MustParseExpr(`a + 1`), nil); out != nil {
+		t.Errorf("unexpected output without a document: %#v", out)
+	}
+}
+
+// Ensure IS NULL tells missing fields apart from unequal ones.
+func TestExecSQL_MissingFields(t *testing.T) {
+	docs := []string{
+		`{"status": 200, "x": 1}`,
+		`{"status": 500, "x": 2}`,
+		`{"x": 4}`,
+		`{"status": null, "x": 8}`,
+	}
+	for i, tt := range []struct {
+		s   string
+		exp float64
+	}{
+		{s: `select sum(x) from foo where status != 200`, exp: 2},
+		{s: `select sum(x) from foo where NOT status = 200`, exp: 2},
+		{s: `select sum(x) from foo where status IS NULL`, exp: 12},
+		{s: `select sum(x) from foo where status != 200 OR status IS NULL`, exp: 14},
+		{s: `select sum(x) from foo where NOT exists(status)`, exp: 12},
+		{s: `select count(*) FILTER (WHERE status IS NOT NULL) from foo`, exp: 2},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		if got := res.Get("").Points[0].Metric; got != tt.exp {
+			t.Errorf("%d. %q: exp=%v got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure BETWEEN and the string operators evaluate as in SQL.
+func TestEval_Predicates(t *testing.T) {
+	js := `{"a": 5, "host": "web-01.example.com", "path": "/api/v1_2", "pct": "100%"}`
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: `a BETWEEN 1 AND 5`, out: true},
+		{in: `a BETWEEN 5 AND 10`, out: true},
+		{in: `a BETWEEN 6 AND 10`, out: false},
+		{in: `a BETWEEN 10 AND 1`, out: false},
+		{in: `a BETWEEN 2 * 2 AND 3 + 3 AND true`, out: true},
+		{in: `NOT a BETWEEN 6 AND 10`, out: true},
+		{in: `a BETWEEN 'a' AND 'z'`, out: false},
+		{in: `host BETWEEN 'a' AND 'z'`, out: true},
+		{in: `m BETWEEN 1 AND 5`, out: nil},
+		{in: `a BETWEEN m AND 5`, out: nil},
+
+		{in: `host LIKE 'web-%'`, out: true},
+		{in: `host LIKE 'web-__.%.com'`, out: true},
+		{in: `host LIKE '%example%'`, out: true},
+		{in: `host LIKE '%.org'`, out: false},
+		{in: `host LIKE 'WEB-%'`, out: false},
+		{in: `host ILIKE 'WEB-%'`, out: true},
+		{in: `host LIKE 'web'`, out: false},
+		{in: `host LIKE '%'`, out: true},
+		{in: `path LIKE '%v1\\_2'`, out: true},
+		{in: `path LIKE '%v1\\_'`, out: false},
+		{in: `pct LIKE '%\\%'`, out: true},
+		{in: `host LIKE '%e%e%e%'`, out: true},
+		{in: `a LIKE '5'`, out: false},
+		{in: `m LIKE '%'`, out: nil},
+
+		{in: `host STARTS WITH 'web'`, out: true},
+		{in: `host STARTS WITH 'example'`, out: false},
+		{in: `host ENDS WITH '.com'`, out: true},
+		{in: `host CONTAINS 'example'`, out: true},
+		{in: `host CONTAINS 'EXAMPLE'`, out: false},
+		{in: `lower(host) CONTAINS lower('EXAMPLE')`, out: true},
+		{in: `host starts with 'web' AND path ends with '_2'`, out: true},
+		{in: `m CONTAINS 'x'`, out: nil},
+	} {
+		if out := jepl.Eval(MustParseExpr(tt.in), &js); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+}
+
+// Ensure numeric literals compare with the float64 numbers of JSON.
+func TestEval_Numbers(t *testing.T) {
+	js := `{"port": 443, "ratio": 0.0025, "big": 1500000000, "neg": -3, "ok": true}`
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: `port IN [80, 443]`, out: true},
+		{in: `port NI [80, 443]`, out: false},
+		{in: `port IN [443.0]`, out: true},
+		{in: `port = 0x1BB`, out: true},
+		{in: `port IN [0x50, 0x1BB]`, out: true},
+		{in: `ratio = 2.5e-3`, out: true},
+		{in: `big > 1e9`, out: true},
+		{in: `big < 1.5E+9`, out: false},
+		{in: `neg IN [-3, -2]`, out: true},
+		{in: `neg -1 = -4`, out: true},
+		{in: `neg - -3 = 0`, out: true},
+		{in: `ok IN [true]`, out: true},
+		{in: `ok NI [false, 1]`, out: true},
+		{in: `port IN [true, '443']`, out: false},
+	} {
+		if out := jepl.Eval(MustParseExpr(tt.in), &js); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+}
+
+// Ensure times compare with event timestamps of any supported format.
+func TestEval_Times(t *testing.T) {
+	js := `{"sec": 1481731200, "ms": 1481731200500, "rfc": "2016-12-15T00:00:00+08:00", "bad": "yesterday", "s": "abc", "raw": "2016-12-14T16:00:00.000Z", "list": ["abc", 1481731200]}`
+	now := &jepl.NowValuer{Now: time.Date(2016, 12, 14, 16, 10, 0, 0, time.UTC)}
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: `sec > now() - 15m`, out: true},
+		{in: `sec > now() - 5m`, out: false},
+		{in: `ms < now() + -10m`, out: false},
+		{in: `rfc >= now() - 10m AND rfc < now()`, out: true},
+		{in: `now() - 10m = sec`, out: true},
+		{in: `-10m + now() = rfc`, out: true},
+		{in: `sec = '2016-12-14T16:00:00Z'`, out: true},
+		{in: `rfc = '2016-12-14T16:00:00Z'`, out: true},
+		{in: `rfc != '2016-12-14T16:00:00Z'`, out: false},
+		{in: `ms > '2016-12-14T16:00:00.4Z'`, out: true},
+		{in: `ms > '2016-12-14T16:00:00.6Z'`, out: false},
+		{in: `ms BETWEEN '2016-12-14T16:00:00Z' AND now()`, out: true},
+		{in: `rfc BETWEEN sec AND ms`, out: false},
+		{in: `bad < now()`, out: false},
+		{in: `missing < now()`, out: nil},
+		{in: `now() - sec`, out: nil},
+		{in: `rfc STARTS WITH '2016-12-15T00:00:00+08:00'`, out: true},
+		{in: `s != '2016-12-14T16:00:00Z'`, out: true},
+		{in: `s = '2016-12-14T16:00:00Z'`, out: false},
+		{in: `'2016-12-14T16:00:00Z' != s`, out: true},
+		{in: `bad = 'yesterday'`, out: true},
+		{in: `raw = '2016-12-14T16:00:00Z'`, out: true},
+		{in: `s < '2016-12-14T16:00:00Z'`, out: false},
+		{in: `any(list) = '2016-12-14T16:00:00Z'`, out: true},
+		{in: `all(list) != '2016-12-14T16:00:00Z'`, out: false},
+	} {
+		if out := jepl.EvalWithValuer(MustParseExpr(tt.in), &js, now); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+
+	if out, ok := jepl.Eval(MustParseExpr(`now() - 1h`), &js).(time.Time); !ok || time.Since(out) < 59*time.Minute {
+		t.Errorf("unexpected now() - 1h: %v", out)
+	}
+}
+
+// Ensure quoted identifiers reach keys that are not bare identifiers.
+func TestEval_QuotedFields(t *testing.T) {
+	js := `{"user-agent": "curl", "@metadata": {"beat": "packetbeat"}, "a.b": {"c": 1}, "select": true}`
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: "`user-agent` = 'curl'", out: true},
+		{in: "@metadata.beat = 'packetbeat'", out: true},
+		{in: "`a.b`.c = 1", out: true},
+		{in: "a.b.c = 1", out: nil},
+		{in: "`select`", out: true},
+	} {
+		if out := jepl.Eval(MustParseExpr(tt.in), &js); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+}
+
+// Ensure array elements can be indexed and quantified with ANY and ALL.
+func TestEval_Arrays(t *testing.T) {
+	js := `{"tags": ["web", "prod"], "ports": [80, 443], "empty": [], "one": "web",
+		"dns": {"answers": [{"data": "10.0.0.1", "ttl": 60}, {"data": "10.0.0.2", "ttl": 300}, {"type": "CNAME"}]},
+		"nested": [[1, 2], [3]]}`
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: `tags[0] = 'web'`, out: true},
+		{in: `tags[1] = 'prod'`, out: true},
+		{in: `tags[2] = 'x'`, out: nil},
+		{in: `dns.answers[1].ttl > 100`, out: true},
+		{in: `dns.answers[2].ttl IS NULL`, out: true},
+		{in: `nested[1][0] = 3`, out: true},
+		{in: `tags = 'web'`, out: nil},
+		{in: `tags IS NOT NULL`, out: true},
+
+		{in: `ANY(tags) = 'prod'`, out: true},
+		{in: `ANY(tags) = 'dev'`, out: false},
+		{in: `'prod' = ANY(tags)`, out: true},
+		{in: `ALL(tags) != 'dev'`, out: true},
+		{in: `ALL(ports) > 100`, out: false},
+		{in: `ANY(ports) > 100`, out: true},
+		{in: `ANY(ports) BETWEEN 400 AND 500`, out: true},
+		{in: `ALL(tags) IN ['web', 'prod', 'dev']`, out: true},
+		{in: `ALL(ports) IN [80, 443]`, out: true},
+		{in: `ANY(ports) IN [0x50]`, out: true},
+		{in: `ports[0x1] = 443`, out: true},
+		{in: `ANY(tags) LIKE 'pr%'`, out: true},
+		{in: `any(one) = 'web'`, out: true},
+		{in: `ANY(empty) = 1`, out: false},
+		{in: `ALL(empty) = 1`, out: true},
+		{in: `ANY(missing) = 1`, out: nil},
+		{in: `NOT ANY(tags) = 'dev'`, out: true},
+
+		{in: `ANY(dns.answers[*].data) IN CIDR('10.0.0.0/30')`, out: true},
+		{in: `ANY(dns.answers[*].data) = '10.0.0.2'`, out: true},
+		{in: `ANY(dns.answers[*].data) = '10.0.0.3'`, out: nil},
+		{in: `ALL(dns.answers[*].ttl) >= 60`, out: nil},
+		{in: `ALL(dns.answers[*].ttl) > 100`, out: false},
+		{in: `ANY(dns.answers[*].type) = 'CNAME'`, out: true},
+		{in: `ANY(nested[*]) = 3`, out: true},
+		{in: `ANY(dns[*].data) = 'x'`, out: nil},
+	} {
+		if out := jepl.Eval(MustParseExpr(tt.in), &js); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+}
+
+// Ensure IP and CIDR literals compare addresses rather than strings.
+func TestEval_Addresses(t *testing.T) {
+	js := `{"src": "10.1.2.3", "dst": "2001:db8::0:1", "mapped": "::ffff:192.168.0.1", "host": "web", "port": 80}`
+	for i, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{in: `src = IP('10.1.2.3')`, out: true},
+		{in: `src = IP('10.1.2.4')`, out: false},
+		{in: `src != IP('10.1.2.4')`, out: true},
+		{in: `IP('10.1.2.3') = src`, out: true},
+		{in: `dst = IP('2001:DB8::1')`, out: true},
+		{in: `mapped = IP('192.168.0.1')`, out: true},
+		{in: `host = IP('10.1.2.3')`, out: false},
+		{in: `host != IP('10.1.2.3')`, out: true},
+		{in: `IP('10.1.2.3') != host`, out: true},
+		{in: `port != IP('10.1.2.3')`, out: true},
+		{in: `port = IP('10.1.2.3')`, out: false},
+		{in: `m = IP('10.1.2.3')`, out: nil},
+
+		{in: `src IN CIDR('10.0.0.0/8')`, out: true},
+		{in: `src IN CIDR('10.2.0.0/16')`, out: false},
+		{in: `src NI CIDR('192.168.0.0/16')`, out: true},
+		{in: `mapped IN CIDR('192.168.0.0/16')`, out: true},
+		{in: `dst IN CIDR('2001:db8::/32')`, out: true},
+		{in: `dst IN CIDR('10.0.0.0/8')`, out: false},
+		{in: `host IN CIDR('10.0.0.0/8')`, out: false},
+		{in: `host NI CIDR('10.0.0.0/8')`, out: true},
+		{in: `m IN CIDR('10.0.0.0/8')`, out: nil},
+
+		{in: `src IN [CIDR('192.168.0.0/16'), CIDR('10.0.0.0/8')]`, out: true},
+		{in: `src IN [CIDR('192.168.0.0/16'), IP('10.1.2.3')]`, out: true},
+		{in: `dst NI [CIDR('fc00::/7'), CIDR('fe80::/10')]`, out: true},
+		{in: `host IN [CIDR('10.0.0.0/8'), 'web']`, out: true},
+		{in: `IP('10.1.2.3') IN [CIDR('10.0.0.0/8')]`, out: true},
+	} {
+		if out := jepl.Eval(MustParseExpr(tt.in), &js); !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("%d. %s: unexpected output: exp=%#v got=%#v", i, tt.in, tt.out, out)
+		}
+	}
+}
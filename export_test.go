@@ -0,0 +1,4 @@
+package jepl
+
+// NumGroups returns the number of groups holding aggregate state.
+func (q *Query) NumGroups() int { return len(q.groups) }
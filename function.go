@@ -0,0 +1,183 @@
+package jepl
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Function describes a scalar function, evaluated once per document.
+// Scalar functions may be used in conditions and in aggregate arguments.
+type Function struct {
+	// Args lists the types of the arguments in order.
+	Args []ArgType
+
+	// Optional is the number of trailing arguments that may be omitted.
+	Optional int
+
+	// Variadic allows the last argument to be repeated.
+	Variadic bool
+
+	// Call returns the value of the function for the evaluated arguments.
+	// Arguments missing from the document are nil.
+	Call func(args []interface{}) interface{}
+}
+
+var (
+	functionsMu sync.RWMutex
+	functionFns = make(map[string]*Function)
+)
+
+func init() {
+	RegisterFunction("lower", &Function{Args: []ArgType{AnyArg}, Call: stringFunc(strings.ToLower)})
+	RegisterFunction("upper", &Function{Args: []ArgType{AnyArg}, Call: stringFunc(strings.ToUpper)})
+	RegisterFunction("len", &Function{Args: []ArgType{AnyArg}, Call: length})
+	RegisterFunction("substr", &Function{Args: []ArgType{AnyArg, AnyArg, AnyArg}, Optional: 1, Call: substr})
+	RegisterFunction("abs", &Function{Args: []ArgType{AnyArg}, Call: numberFunc(math.Abs)})
+	RegisterFunction("round", &Function{Args: []ArgType{AnyArg}, Call: numberFunc(math.Round)})
+	RegisterFunction("floor", &Function{Args: []ArgType{AnyArg}, Call: numberFunc(math.Floor)})
+	RegisterFunction("ceil", &Function{Args: []ArgType{AnyArg}, Call: numberFunc(math.Ceil)})
+	RegisterFunction("coalesce", &Function{Args: []ArgType{AnyArg}, Variadic: true, Call: coalesce})
+	RegisterFunction("exists", &Function{Args: []ArgType{FieldArg}, Call: exists})
+	RegisterFunction("now", &Function{Call: now})
+	RegisterFunction("any", &Function{Args: []ArgType{FieldArg}, Call: elements})
+	RegisterFunction("all", &Function{Args: []ArgType{FieldArg}, Call: elements})
+}
+
+// RegisterFunction makes a scalar function available to statements parsed
+// afterwards. It panics if fn is invalid or name is already registered as
+// a function or an aggregate.
+func RegisterFunction(name string, fn *Function) {
+	if fn == nil || fn.Call == nil {
+		panic("jepl: RegisterFunction function is nil")
+	}
+	if fn.Optional > len(fn.Args) || (fn.Variadic && len(fn.Args) == 0) {
+		panic("jepl: RegisterFunction invalid arguments for " + name)
+	}
+
+	name = strings.ToLower(name)
+	if _, ok := lookupAggregate(name); ok {
+		panic("jepl: RegisterFunction called for aggregate " + name)
+	}
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	if _, dup := functionFns[name]; dup {
+		panic("jepl: RegisterFunction called twice for " + name)
+	}
+	functionFns[name] = fn
+}
+
+// lookupFunction returns the scalar function registered as name.
+func lookupFunction(name string) (*Function, bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	fn, ok := functionFns[strings.ToLower(name)]
+	return fn, ok
+}
+
+// validate checks the arguments of c against the signature of the function.
+func (fn *Function) validate(c *Call) error {
+	if c.Filter != nil {
+		return fmt.Errorf("FILTER is only supported by aggregate functions, got %s()", c.Name)
+	}
+	return validateCallArgs(c, fn.Args, fn.Optional, fn.Variadic)
+}
+
+// stringFunc returns a function applying f to a string argument.
+func stringFunc(f func(string) string) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if s, ok := args[0].(string); ok {
+			return f(s)
+		}
+		return nil
+	}
+}
+
+// numberFunc returns a function applying f to a numeric argument.
+func numberFunc(f func(float64) float64) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if v, ok := number(args[0]); ok {
+			return f(v)
+		}
+		return nil
+	}
+}
+
+// length returns the number of characters of a string.
+func length(args []interface{}) interface{} {
+	if s, ok := args[0].(string); ok {
+		return float64(utf8.RuneCountInString(s))
+	}
+	return nil
+}
+
+// substr returns the characters of a string from a 1-based position,
+// up to an optional length.
+func substr(args []interface{}) interface{} {
+	s, ok := args[0].(string)
+	if !ok {
+		return nil
+	}
+	pos, ok := number(args[1])
+	if !ok {
+		return nil
+	}
+
+	// Clamp while still float64 so huge or negative values cannot
+	// overflow the conversion to int.
+	r := []rune(s)
+	start := 0
+	if pos > float64(len(r)) {
+		start = len(r)
+	} else if pos > 1 {
+		start = int(pos) - 1
+	}
+	end := len(r)
+	if len(args) > 2 {
+		n, ok := number(args[2])
+		if !ok {
+			return nil
+		}
+		if n <= 0 {
+			end = start
+		} else if n < float64(end-start) {
+			end = start + int(n)
+		}
+	}
+	return string(r[start:end])
+}
+
+// coalesce returns the first argument present in the document.
+func coalesce(args []interface{}) interface{} {
+	for _, arg := range args {
+		if arg != nil {
+			return arg
+		}
+	}
+	return nil
+}
+
+// exists reports whether a field is present in the document and not null.
+func exists(args []interface{}) interface{} {
+	return args[0] != nil
+}
+
+// now returns the current time. It is replaced by the value of "now()"
+// when evaluated with a Valuer that has one.
+func now(args []interface{}) interface{} {
+	return time.Now().UTC()
+}
+
+// elements returns the elements of an array, as quantified by any() and
+// all() in a comparison. A single value is an array of one element.
+func elements(args []interface{}) interface{} {
+	switch v := args[0].(type) {
+	case nil, []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
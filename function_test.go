@@ -0,0 +1,122 @@
+package jepl_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure the built-in scalar functions evaluate against a document.
+func TestEval_Functions(t *testing.T) {
+	js := `{"host": "WWW.Example.com", "name": "héllo", "delta": -2.5, "n": 3}`
+
+	for i, tt := range []struct {
+		s   string
+		exp interface{}
+	}{
+		{s: `lower(host)`, exp: "www.example.com"},
+		{s: `UPPER(host)`, exp: "WWW.EXAMPLE.COM"},
+		{s: `lower(delta)`, exp: nil},
+		{s: `len(name)`, exp: float64(5)},
+		{s: `len(missing)`, exp: nil},
+		{s: `substr(host, 5)`, exp: "Example.com"},
+		{s: `substr(host, 5, 7)`, exp: "Example"},
+		{s: `substr(name, 2, n)`, exp: "éll"},
+		{s: `substr(name, 0, 2)`, exp: "hé"},
+		{s: `substr(name, 9)`, exp: ""},
+		{s: `substr(name, 2, 1e19)`, exp: "éllo"},
+		{s: `substr(name, 1e19, 2)`, exp: ""},
+		{s: `substr(name, -1e19, 2)`, exp: "hé"},
+		{s: `substr(name, 2, -3)`, exp: ""},
+		{s: `substr(name, 2, -1e19)`, exp: ""},
+		{s: `abs(delta)`, exp: 2.5},
+		{s: `abs(-2)`, exp: float64(2)},
+		{s: `round(delta)`, exp: float64(-3)},
+		{s: `floor(delta)`, exp: float64(-3)},
+		{s: `ceil(delta)`, exp: float64(-2)},
+		{s: `ceil(delta) * n`, exp: float64(-6)},
+		{s: `coalesce(missing, n, host)`, exp: float64(3)},
+		{s: `coalesce(missing, other)`, exp: nil},
+		{s: `lower(substr(host, 1, 3)) = 'www'`, exp: true},
+	} {
+		expr := MustParseExpr(tt.s)
+		if got := jepl.Eval(expr, &js); !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: exp=%#v got=%#v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure scalar functions can be used in conditions and aggregate arguments.
+func TestExecSQL_Functions(t *testing.T) {
+	docs := []string{
+		`{"host": "A.com", "delta": -2}`,
+		`{"host": "a.com", "delta": 3}`,
+		`{"host": "b.com", "delta": -4.5}`,
+		`{"host": "B.com"}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+		err string
+	}{
+		{s: `select sum(delta) from foo where lower(host) = 'a.com'`, exp: []float64{1}},
+		{s: `select sum(abs(delta)) from foo`, exp: []float64{9.5}},
+		{s: `select sum(abs(delta) * 2), count(host) from foo where len(host) = 5`, exp: []float64{19, 4}},
+		{s: `select sum(coalesce(delta, 10)) from foo where upper(host) =~ /^B/`, exp: []float64{5.5}},
+		{s: `select round(avg(delta)) from foo`, exp: []float64{-1}},
+		{s: `select floor(sum(delta)) + ceil(max(delta)) from foo`, exp: []float64{-1}},
+		{s: `select sum(x) from foo where lower(sum(x)) = 'a'`, err: `invalid filter, unsupport function sum(x)`},
+		{s: `select sum(x) from foo where lower() = 'a'`, err: `invalid number of arguments for lower, expected 1, got 0`},
+		{s: `select sum(x) from foo where substr(x) = 'a'`, err: `invalid number of arguments for substr, expected 2 to 3, got 1`},
+		{s: `select sum(x) from foo where coalesce() = 'a'`, err: `invalid number of arguments for coalesce, expected at least 1, got 0`},
+		{s: `select sum(abs(sum(x))) from foo`, err: `argument binary expressions cannot mix function`},
+		{s: `select abs(x) from foo`, err: `invalid field abs(x) in SELECT field, at least one function`},
+		{s: `select abs(sum(x) + y) from foo`, err: `binary expressions cannot mix aggregates and raw fields`},
+		{s: `select count(lower(x)) from foo`, err: `expected only field argument in count()`},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure registered scalar functions are validated and evaluated.
+func TestRegisterFunction(t *testing.T) {
+	jepl.RegisterFunction("test_double", &jepl.Function{
+		Args: []jepl.ArgType{jepl.AnyArg},
+		Call: func(args []interface{}) interface{} {
+			if v, ok := args[0].(float64); ok {
+				return v * 2
+			}
+			return nil
+		},
+	})
+
+	res, err := jepl.ExecSQL(`select sum(test_double(x)) from foo where test_double(x) > 2`, []string{`{"x": 1}`, `{"x": 2}`, `{"x": 3}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Get("").Points[0].Metric; got != 10 {
+		t.Fatalf("exp=10 got=%v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	jepl.RegisterFunction("sum", &jepl.Function{Args: []jepl.ArgType{jepl.AnyArg}, Call: func([]interface{}) interface{} { return nil }})
+}
@@ -4,37 +4,16 @@ import (
 	"regexp"
 )
 
-//FlatStatByGroup divergent multi SelectStatement based on group by clause
+// FlatStatByGroup divergent multi SelectStatement based on group by clause
+//
+// Deprecated: evaluating every document against every group statement is
+// quadratic. Use Query, which hashes each document into its group once.
 func (s *SelectStatement) FlatStatByGroup(docs []string) map[string]*SelectStatement {
-	var groups = make(map[string]*BinaryExpr)
+	var groups = make(map[string]Expr)
 	m := make(map[string]*SelectStatement)
 	for _, doc := range docs {
-		// Dummy root node.
-		root := &BinaryExpr{}
-
-		for _, dimension := range s.Dimensions {
-
-			res := Eval(dimension.Expr, &doc)
-			var lhs Expr
-			switch v := res.(type) {
-			case string:
-				lhs = &StringLiteral{Val: v}
-			case float64:
-				lhs = &NumberLiteral{Val: v}
-			case bool:
-				lhs = &BooleanLiteral{Val: v}
-			default:
-			}
-			rhs := &BinaryExpr{LHS: lhs, Op: EQ, RHS: dimension.Expr}
-
-			if root.LHS == nil {
-				root = &BinaryExpr{LHS: &BooleanLiteral{Val: true}, Op: AND, RHS: rhs}
-			} else {
-				root = &BinaryExpr{LHS: root, Op: AND, RHS: rhs}
-			}
-		}
-		root = &BinaryExpr{LHS: root, Op: AND, RHS: s.Condition}
-		groups[root.String()] = root
+		root := s.groupCondition(s.dimensionTags(&doc))
+		groups[condString(root)] = root
 	}
 
 	for k, v := range groups {
@@ -45,13 +24,72 @@ func (s *SelectStatement) FlatStatByGroup(docs []string) map[string]*SelectState
 	return m
 }
 
+// fieldDimensions returns the dimensions grouping by a field.
+// Time dimensions bucket a group rather than split it, so they are skipped.
+func (s *SelectStatement) fieldDimensions() []*VarRef {
+	var refs []*VarRef
+	for _, d := range s.Dimensions {
+		if ref, ok := d.Expr.(*VarRef); ok {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// dimensionTags maps the field dimension names to their values in js.
+func (s *SelectStatement) dimensionTags(js *string) map[string]interface{} {
+	refs := s.fieldDimensions()
+	tags := make(map[string]interface{}, len(refs))
+	for _, ref := range refs {
+		tags[ref.Val] = Eval(ref, js)
+	}
+	return tags
+}
+
+// groupCondition builds the condition selecting the group with the given
+// dimension tags, i.e. the tag values ANDed with the WHERE clause.
+func (s *SelectStatement) groupCondition(tags map[string]interface{}) Expr {
+	// Dummy root node.
+	root := &BinaryExpr{}
+
+	for _, ref := range s.fieldDimensions() {
+		var lhs Expr
+		switch v := tags[ref.Val].(type) {
+		case string:
+			lhs = &StringLiteral{Val: v}
+		case float64:
+			lhs = &NumberLiteral{Val: v}
+		case bool:
+			lhs = &BooleanLiteral{Val: v}
+		default:
+			lhs = &nilLiteral{}
+		}
+		rhs := &BinaryExpr{LHS: lhs, Op: EQ, RHS: ref}
+
+		if root.LHS == nil {
+			root = &BinaryExpr{LHS: &BooleanLiteral{Val: true}, Op: AND, RHS: rhs}
+		} else {
+			root = &BinaryExpr{LHS: root, Op: AND, RHS: rhs}
+		}
+	}
+	if root.LHS == nil {
+		return s.Condition
+	}
+	if s.Condition != nil {
+		root = &BinaryExpr{LHS: root, Op: AND, RHS: s.Condition}
+	}
+	return root
+}
+
 // Clone returns a deep copy of the statement.
 func (s *SelectStatement) Clone() *SelectStatement {
 	clone := *s
 	clone.Fields = make(Fields, 0, len(s.Fields))
 	clone.Dimensions = make(Dimensions, 0, len(s.Dimensions))
+	clone.SortFields = make(SortFields, 0, len(s.SortFields))
 	clone.Sources = cloneSources(s.Sources)
 	clone.Condition = CloneExpr(s.Condition)
+	clone.Having = CloneExpr(s.Having)
 
 	for _, f := range s.Fields {
 		clone.Fields = append(clone.Fields, &Field{Expr: CloneExpr(f.Expr), Alias: f.Alias})
@@ -59,6 +97,9 @@ func (s *SelectStatement) Clone() *SelectStatement {
 	for _, d := range s.Dimensions {
 		clone.Dimensions = append(clone.Dimensions, &Dimension{Expr: CloneExpr(d.Expr)})
 	}
+	for _, f := range s.SortFields {
+		clone.SortFields = append(clone.SortFields, &SortField{Expr: CloneExpr(f.Expr), Ascending: f.Ascending})
+	}
 
 	return &clone
 }
@@ -95,14 +136,24 @@ func CloneExpr(expr Expr) Expr {
 		return &BinaryExpr{Op: expr.Op, LHS: CloneExpr(expr.LHS), RHS: CloneExpr(expr.RHS)}
 	case *BooleanLiteral:
 		return &BooleanLiteral{Val: expr.Val}
+	case *BoundParameter:
+		return &BoundParameter{Name: expr.Name}
 	case *Call:
 		args := make([]Expr, len(expr.Args))
 		for i, arg := range expr.Args {
 			args[i] = CloneExpr(arg)
 		}
-		return &Call{Name: expr.Name, Args: args}
+		return &Call{Name: expr.Name, Args: args, Filter: CloneExpr(expr.Filter)}
+	case *DurationLiteral:
+		return &DurationLiteral{Val: expr.Val}
 	case *IntegerLiteral:
 		return &IntegerLiteral{Val: expr.Val}
+	case *ListLiteral:
+		return &ListLiteral{Vals: append([]interface{}(nil), expr.Vals...)}
+	case *IPLiteral:
+		return &IPLiteral{Val: expr.Val}
+	case *CIDRLiteral:
+		return &CIDRLiteral{Val: expr.Val}
 	case *NumberLiteral:
 		return &NumberLiteral{Val: expr.Val}
 	case *ParenExpr:
@@ -111,6 +162,12 @@ func CloneExpr(expr Expr) Expr {
 		return &RegexLiteral{Val: expr.Val}
 	case *StringLiteral:
 		return &StringLiteral{Val: expr.Val}
+	case *TimeLiteral:
+		return &TimeLiteral{Val: expr.Val, Raw: expr.Raw}
+	case *NullLiteral:
+		return &NullLiteral{}
+	case *UnaryExpr:
+		return &UnaryExpr{Op: expr.Op, Expr: CloneExpr(expr.Expr)}
 	case *VarRef:
 		return &VarRef{Val: expr.Val, Segments: expr.Segments[:]}
 	}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Parser represents an InfluxQL parser.
@@ -46,7 +48,7 @@ func (p *Parser) parseIdent() (string, error) {
 }
 
 // parseSegmentedIdents parses a segmented identifiers.
-// e.g.,  tcp.in_bytes
+// e.g.,  tcp.in_bytes, dns.answers[0].data, tags[*]
 func (p *Parser) parseSegmentedIdents() ([]string, error) {
 	ident, err := p.parseIdent()
 	if err != nil {
@@ -54,9 +56,17 @@ func (p *Parser) parseSegmentedIdents() ([]string, error) {
 	}
 	idents := []string{ident}
 
-	// Parse remaining (optional) identifiers.
+	// Parse remaining (optional) identifiers and array indexes.
 	for {
-		if tok, _, _ := p.scan(); tok != DOT {
+		tok, _, _ := p.scan()
+		if tok == LBRACKET {
+			index, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			idents = append(idents, index)
+			continue
+		} else if tok != DOT {
 			// No more segments so we're done.
 			p.unscan()
 			break
@@ -72,6 +82,25 @@ func (p *Parser) parseSegmentedIdents() ([]string, error) {
 	return idents, nil
 }
 
+// parseIndex parses an array index segment, either a position or the
+// wildcard "*". This function assumes the LBRACKET has been consumed.
+func (p *Parser) parseIndex() (string, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == MUL {
+		lit = "*"
+	} else if tok != INTEGER {
+		return "", newParseError(tokstr(tok, lit), []string{"integer", "*"}, pos)
+	} else if n, err := parseInteger(lit); err != nil {
+		return "", &ParseError{Message: "unable to parse integer", Pos: pos}
+	} else {
+		lit = strconv.FormatInt(n, 10)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RBRACKET {
+		return "", newParseError(tokstr(tok, lit), []string{"]"}, pos)
+	}
+	return "[" + lit + "]", nil
+}
+
 // parseSelectStatement parses a select string and returns a Statement AST object.
 // This function assumes the SELECT token has already been consumed.
 func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
@@ -101,6 +130,26 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		return nil, err
 	}
 
+	// Parse group condition: "HAVING EXPR".
+	if stmt.Having, err = p.parseHaving(); err != nil {
+		return nil, err
+	}
+
+	// Parse sort: "ORDER BY FIELD+".
+	if stmt.SortFields, err = p.parseOrderBy(); err != nil {
+		return nil, err
+	}
+
+	// Parse limit: "LIMIT <n>".
+	if stmt.Limit, err = p.parseOptionalTokenAndInt(LIMIT); err != nil {
+		return nil, err
+	}
+
+	// Parse offset: "OFFSET <n>".
+	if stmt.Offset, err = p.parseOptionalTokenAndInt(OFFSET); err != nil {
+		return nil, err
+	}
+
 	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
 		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
 	}
@@ -177,13 +226,19 @@ type validateField struct {
 }
 
 func (c *validateField) Visit(n Node) Visitor {
+	if e, ok := n.(*UnaryExpr); ok && e.Op == NOT {
+		c.foundInvalid = true
+		c.badToken = e.Op
+		return nil
+	}
 	e, ok := n.(*BinaryExpr)
 	if !ok {
 		return c
 	}
 
 	switch e.Op {
-	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE, AND, OR, IN, NI:
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE, AND, OR, IN, NI, IS, ISNOT,
+		BETWEEN, LIKE, ILIKE, STARTSWITH, ENDSWITH, CONTAINS:
 		c.foundInvalid = true
 		c.badToken = e.Op
 		return nil
@@ -266,6 +321,74 @@ func (p *Parser) parseCondition() (Expr, error) {
 	return expr, nil
 }
 
+// parseHaving parses the "HAVING" clause of the query, if it exists.
+func (p *Parser) parseHaving() (Expr, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != HAVING {
+		p.unscan()
+		return nil, nil
+	}
+	return p.ParseExpr()
+}
+
+// parseOrderBy parses the "ORDER BY" clause of the query, if it exists.
+func (p *Parser) parseOrderBy() (SortFields, error) {
+	// If the next token is not ORDER then exit.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != ORDER {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Now the next token should be "BY".
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
+		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+
+	var fields SortFields
+	for {
+		// Parse the sort expression and its optional direction.
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		field := &SortField{Expr: expr, Ascending: true}
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == DESC {
+			field.Ascending = false
+		} else if tok != ASC {
+			p.unscan()
+		}
+		fields = append(fields, field)
+
+		// If there's not a comma next then stop parsing sort fields.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	return fields, nil
+}
+
+// parseOptionalTokenAndInt parses the specified token followed
+// by an integer, if it exists.
+func (p *Parser) parseOptionalTokenAndInt(t Token) (int, error) {
+	// Check if the token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != t {
+		p.unscan()
+		return 0, nil
+	}
+
+	// Scan the number.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != INTEGER {
+		return 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+	}
+
+	n, err := parseInteger(lit)
+	if err != nil || int64(int(n)) != n {
+		return 0, &ParseError{Message: fmt.Sprintf("%s is out of range", tokens[t]), Pos: pos}
+	}
+	return int(n), nil
+}
+
 // parseDimensions parses the "GROUP BY" clause of the query, if it exists.
 func (p *Parser) parseDimensions() (Dimensions, error) {
 	// If the next token is not GROUP then exit.
@@ -327,7 +450,7 @@ func (p *Parser) parseVarRef() (*VarRef, error) {
 	if err != nil {
 		return nil, err
 	}
-	vr := &VarRef{Val: strings.Join(segments, "."), Segments: segments}
+	vr := &VarRef{Val: joinSegments(segments, nil), Segments: segments}
 	return vr, nil
 }
 
@@ -340,8 +463,15 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 	}
 
 	for {
-		// Read next token.
+		// Read next token, applying the sign of a number.
 		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == ADD || tok == SUB {
+			sign := tokens[tok]
+			if tok, pos, lit = p.scanIgnoreWhitespace(); tok != NUMBER && tok != INTEGER {
+				return nil, newParseError(tokstr(tok, lit), []string{"float", "integer"}, pos)
+			}
+			lit = sign + lit
+		}
 		switch tok {
 		case STRING:
 			list.Vals = append(list.Vals, lit)
@@ -352,14 +482,31 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 			}
 			list.Vals = append(list.Vals, v)
 		case INTEGER:
-			v, err := strconv.ParseInt(lit, 10, 64)
+			v, err := parseInteger(lit)
 			if err != nil {
 				return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
 			}
 			list.Vals = append(list.Vals, v)
+		case TRUE, FALSE:
+			list.Vals = append(list.Vals, tok == TRUE)
+		case IDENT:
+			if tok0, _, _ := p.scan(); tok0 != LPAREN || !isAddressFunc(lit) {
+				p.unscan()
+				return nil, newParseError(tokstr(tok, lit), []string{"string", "float", "integer", "bool", "IP", "CIDR"}, pos)
+			}
+			addr, err := p.parseAddress(lit)
+			if err != nil {
+				return nil, err
+			}
+			switch addr := addr.(type) {
+			case *IPLiteral:
+				list.Vals = append(list.Vals, addr.Val)
+			case *CIDRLiteral:
+				list.Vals = append(list.Vals, addr.Val)
+			}
 		default:
 			p.unscan()
-			return nil, newParseError(tokstr(tok, lit), []string{"string", "float", "integer"}, pos)
+			return nil, newParseError(tokstr(tok, lit), []string{"string", "float", "integer", "bool", "IP", "CIDR"}, pos)
 		}
 
 		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
@@ -375,8 +522,106 @@ func (p *Parser) parseList() (*ListLiteral, error) {
 	return list, nil
 }
 
+// parseInteger parses a decimal or hexadecimal integer literal, with an
+// optional sign.
+func parseInteger(lit string) (int64, error) {
+	sign := ""
+	if strings.HasPrefix(lit, "-") || strings.HasPrefix(lit, "+") {
+		sign, lit = lit[:1], lit[1:]
+	}
+	if strings.HasPrefix(lit, "0x") || strings.HasPrefix(lit, "0X") {
+		return strconv.ParseInt(sign+lit[2:], 16, 64)
+	}
+	return strconv.ParseInt(sign+lit, 10, 64)
+}
+
+// parseListOrCIDR parses the operand of IN and NI, either a list,
+// a single CIDR literal or a bound parameter.
+func (p *Parser) parseListOrCIDR() (Expr, error) {
+	tok, _, lit := p.scanIgnoreWhitespace()
+	if tok == BOUNDPARAM {
+		return &BoundParameter{Name: lit}, nil
+	} else if tok == IDENT && strings.EqualFold(lit, "cidr") {
+		if tok0, _, _ := p.scan(); tok0 == LPAREN {
+			return p.parseAddress(lit)
+		}
+		p.unscan()
+	}
+	p.unscan()
+	return p.parseList()
+}
+
+// isAddressFunc returns true if name introduces an IP or CIDR literal.
+func isAddressFunc(name string) bool {
+	return strings.EqualFold(name, "ip") || strings.EqualFold(name, "cidr")
+}
+
+// parseAddress parses the quoted address of an IP('...') or CIDR('...')
+// literal. The opening parenthesis has already been consumed.
+func (p *Parser) parseAddress(name string) (Expr, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != STRING {
+		return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+	}
+
+	var expr Expr
+	if strings.EqualFold(name, "ip") {
+		ip := net.ParseIP(lit)
+		if ip == nil {
+			return nil, &ParseError{Message: fmt.Sprintf("invalid IP address %s", QuoteString(lit)), Pos: pos}
+		}
+		expr = &IPLiteral{Val: ip}
+	} else {
+		_, ipnet, err := net.ParseCIDR(lit)
+		if err != nil {
+			return nil, &ParseError{Message: fmt.Sprintf("invalid CIDR address %s", QuoteString(lit)), Pos: pos}
+		}
+		expr = &CIDRLiteral{Val: ipnet}
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return expr, nil
+}
+
 // ParseExpr parses an expression.
+// RFC3339 strings compared with =, !=, <, <=, >, >= or BETWEEN are parsed
+// as times. With = and != they still match a value that is not a
+// timestamp as a string.
 func (p *Parser) ParseExpr() (Expr, error) {
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	WalkFunc(expr, func(n Node) {
+		if n, ok := n.(*BinaryExpr); ok {
+			switch n.Op {
+			case EQ, NEQ, LT, LTE, GT, GTE:
+				n.LHS, n.RHS = timeLiteral(n.LHS), timeLiteral(n.RHS)
+			case BETWEEN:
+				if bounds, ok := n.RHS.(*BinaryExpr); ok {
+					bounds.LHS, bounds.RHS = timeLiteral(bounds.LHS), timeLiteral(bounds.RHS)
+				}
+			}
+		}
+	})
+	return expr, nil
+}
+
+// timeLiteral returns expr as a TimeLiteral if it is an RFC3339 string.
+func timeLiteral(expr Expr) Expr {
+	if s, ok := expr.(*StringLiteral); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s.Val); err == nil {
+			return &TimeLiteral{Val: t, Raw: s.Val}
+		}
+	}
+	return expr
+}
+
+// parseExpr parses an expression of the operators binding tighter than
+// the precedence prec. Looser operators are left for the caller.
+func (p *Parser) parseExpr(prec int) (Expr, error) {
 	var err error
 	// Dummy root node.
 	root := &BinaryExpr{}
@@ -392,30 +637,67 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	for {
 		// If the next token is NOT an operator then return the expression.
 		op, _, _ := p.scanIgnoreWhitespace()
-		if !op.isOperator() {
+		if !op.isOperator() || op.Precedence() <= prec {
 			p.unscan()
 			return root.RHS, nil
 		}
 
 		// Otherwise parse the next expression.
 		var rhs Expr
-		if IsRegexOp(op) {
-			// RHS of a regex operator must be a regular expression.
-			p.consumeWhitespace()
-			if rhs, err = p.parseRegex(); err != nil {
+		if op == IS {
+			// The only operand of IS is "[NOT] NULL".
+			tok, pos, lit := p.scanIgnoreWhitespace()
+			if tok == NOT {
+				op = ISNOT
+				tok, pos, lit = p.scanIgnoreWhitespace()
+			}
+			if tok != NULL {
+				return nil, newParseError(tokstr(tok, lit), []string{"NULL"}, pos)
+			}
+			rhs = &NullLiteral{}
+		} else if op == BETWEEN {
+			// The bounds are kept as "lower AND upper". They bind tighter
+			// than comparisons, so a following AND is not consumed.
+			lower, err := p.parseExpr(op.Precedence())
+			if err != nil {
 				return nil, err
 			}
-			// parseRegex can return an empty type, but we need it to be present
-			if rhs.(*RegexLiteral) == nil {
+			if tok, pos, lit := p.scanIgnoreWhitespace(); tok != AND {
+				return nil, newParseError(tokstr(tok, lit), []string{"AND"}, pos)
+			}
+			upper, err := p.parseExpr(op.Precedence())
+			if err != nil {
+				return nil, err
+			}
+			rhs = &BinaryExpr{Op: AND, LHS: lower, RHS: upper}
+		} else if IsRegexOp(op) {
+			// RHS of a regex operator must be a regular expression
+			// or a bound parameter.
+			p.consumeWhitespace()
+			if p.peekRune() == '$' {
+				tok, pos, lit := p.scan()
+				if tok != BOUNDPARAM {
+					return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+				}
+				rhs = &BoundParameter{Name: lit}
+			} else if rhs, err = p.parseRegex(); err != nil {
+				return nil, err
+			} else if rhs.(*RegexLiteral) == nil {
+				// parseRegex can return an empty type, but we need it to be present
 				tok, pos, lit := p.scanIgnoreWhitespace()
 				return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
 			}
 		} else if IsListOp(op) {
 			p.consumeWhitespace()
-			if rhs, err = p.parseList(); err != nil {
+			if rhs, err = p.parseListOrCIDR(); err != nil {
 				return nil, err
 			}
 		} else {
+			if op == STARTSWITH || op == ENDSWITH {
+				if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WITH {
+					return nil, newParseError(tokstr(tok, lit), []string{"WITH"}, pos)
+				}
+			}
 			if rhs, err = p.parseUnaryExpr(); err != nil {
 				return nil, err
 			}
@@ -457,11 +739,58 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 	// Read next token.
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	switch tok {
+	case NOT:
+		// NOT applies to a comparison, so it binds looser than comparisons
+		// and tighter than AND.
+		expr, err := p.parseExpr(AND.Precedence())
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: NOT, Expr: expr}, nil
+	case ADD, SUB:
+		expr, err := p.parseUnaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		if tok == ADD {
+			return expr, nil
+		}
+		// Fold the sign into numeric literals.
+		switch expr := expr.(type) {
+		case *IntegerLiteral:
+			return &IntegerLiteral{Val: -expr.Val}, nil
+		case *NumberLiteral:
+			return &NumberLiteral{Val: -expr.Val}, nil
+		case *DurationLiteral:
+			return &DurationLiteral{Val: -expr.Val}, nil
+		}
+		return &UnaryExpr{Op: SUB, Expr: expr}, nil
+	case ANY, ALL:
+		// ANY(field) and ALL(field) quantify a comparison over the
+		// elements of an array.
+		if tok0, pos, lit := p.scan(); tok0 != LPAREN {
+			return nil, newParseError(tokstr(tok0, lit), []string{"("}, pos)
+		}
+		call, err := p.parseCall(tokens[tok])
+		if err != nil {
+			return nil, err
+		}
+		return call, nil
 	case IDENT:
 		// If the next immediate token is a left parentheses, parse as function call.
 		// Otherwise parse as a variable reference.
 		if tok0, _, _ := p.scan(); tok0 == LPAREN {
-			return p.parseCall(lit)
+			if isAddressFunc(lit) {
+				return p.parseAddress(lit)
+			}
+			call, err := p.parseCall(lit)
+			if err != nil {
+				return nil, err
+			}
+			if call.Filter, err = p.parseFilter(); err != nil {
+				return nil, err
+			}
+			return call, nil
 		}
 
 		p.unscan() // unscan the last token (wasn't an LPAREN)
@@ -478,13 +807,21 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		}
 		return &NumberLiteral{Val: v}, nil
 	case INTEGER:
-		v, err := strconv.ParseInt(lit, 10, 64)
+		v, err := parseInteger(lit)
 		if err != nil {
 			return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
 		}
 		return &IntegerLiteral{Val: v}, nil
+	case DURATIONVAL:
+		v, err := ParseDuration(lit)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse duration", Pos: pos}
+		}
+		return &DurationLiteral{Val: v}, nil
 	case TRUE, FALSE:
 		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+	case BOUNDPARAM:
+		return &BoundParameter{Name: lit}, nil
 	case REGEX:
 		re, err := regexp.Compile(lit)
 		if err != nil {
@@ -529,11 +866,59 @@ func (p *Parser) parseRegex() (*RegexLiteral, error) {
 	return &RegexLiteral{Val: re}, nil
 }
 
+// parseFilter parses the optional FILTER (WHERE cond) clause of a call.
+// Only aggregate calls accept one, which is checked on validation.
+func (p *Parser) parseFilter() (Expr, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FILTER {
+		p.unscan()
+		return nil, nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WHERE {
+		return nil, newParseError(tokstr(tok, lit), []string{"WHERE"}, pos)
+	}
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return expr, nil
+}
+
 // parseCall parses a function call.
 // This function assumes the function name and LPAREN have been consumed.
 func (p *Parser) parseCall(name string) (*Call, error) {
 	name = strings.ToLower(name)
 
+	// count(DISTINCT x) is shorthand for distinct_count(x).
+	if tok, pos, _ := p.scanIgnoreWhitespace(); tok == DISTINCT {
+		if name != "count" {
+			return nil, &ParseError{Message: "DISTINCT is only supported in count()", Pos: pos}
+		}
+		ref, err := p.parseVarRef()
+		if err != nil {
+			return nil, err
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+		return &Call{Name: "distinct_count", Args: []Expr{ref}}, nil
+	} else if tok == MUL {
+		// count(*) is the same as count(), counting every event.
+		if name != "count" {
+			return nil, &ParseError{Message: "* is only supported in count()", Pos: pos}
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+		return &Call{Name: name}, nil
+	}
+	p.unscan()
+
 	// Parse first function argument if one exists.
 	var args []Expr
 	re, err := p.parseRegex()
@@ -544,7 +929,7 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 	} else {
 		// If there's a right paren then just return immediately.
 		if tok, _, _ := p.scan(); tok == RPAREN {
-			return &Call{Name: name, First: true}, nil
+			return &Call{Name: name}, nil
 		}
 		p.unscan()
 
@@ -584,7 +969,7 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
 	}
 
-	return &Call{Name: name, Args: args, First: true}, nil
+	return &Call{Name: name, Args: args}, nil
 }
 
 // scan returns the next token from the underlying scanner.
@@ -611,7 +996,7 @@ func (p *Parser) unscan() { p.s.Unscan() }
 
 var (
 	qsReplacer = strings.NewReplacer("\n", `\n`, `\`, `\\`, `'`, `\'`)
-	qiReplacer = strings.NewReplacer("\n", `\n`, `\`, `\\`, `"`, `\"`)
+	qiReplacer = strings.NewReplacer("\n", `\n`, `\`, `\\`, "`", "\\`")
 )
 
 // QuoteString returns a quoted string.
@@ -619,21 +1004,21 @@ func QuoteString(s string) string {
 	return `'` + qsReplacer.Replace(s) + `'`
 }
 
-// QuoteIdent returns a quoted identifier from multiple bare identifiers.
+// QuoteIdent returns an identifier from its segments, quoting with
+// backticks the segments that are not bare identifiers.
 func QuoteIdent(segments ...string) string {
 	var buf bytes.Buffer
 	for i, segment := range segments {
-		needQuote := IdentNeedsQuotes(segment) ||
-			((i < len(segments)-1) && segment != "") // not last segment && not ""
+		needQuote := IdentNeedsQuotes(segment)
 
 		if needQuote {
-			_ = buf.WriteByte('"')
+			_ = buf.WriteByte('`')
 		}
 
 		_, _ = buf.WriteString(qiReplacer.Replace(segment))
 
 		if needQuote {
-			_ = buf.WriteByte('"')
+			_ = buf.WriteByte('`')
 		}
 
 		if i < len(segments)-1 {
@@ -647,7 +1032,7 @@ func QuoteIdent(segments ...string) string {
 func IdentNeedsQuotes(ident string) bool {
 	// check if this identifier is a keyword
 	tok := Lookup(ident)
-	if tok != IDENT {
+	if tok != IDENT || ident == "" {
 		return true
 	}
 	for i, r := range ident {
@@ -3,10 +3,12 @@ package jepl_test
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/chenyoufu/jepl"
 )
@@ -20,6 +22,10 @@ func TestParseGroupBy(t *testing.T) {
 	}{
 		{s: `SELECT sum(x) FROM Packetbeat where uid="xxx" group by tcp.src_ip`, d: `tcp.src_ip`, err: ``},
 		{s: `SELECT sum(x) FROM Packetbeat group by tcp.src_ip, tcp.dst_ip`, d: `tcp.src_ip, tcp.dst_ip`, err: ``},
+		{s: `SELECT sum(x) FROM Packetbeat group by time(1m)`, d: `time(1m)`, err: ``},
+		{s: `SELECT sum(x) FROM Packetbeat group by tcp.src_ip, time(90s)`, d: `tcp.src_ip, time(90s)`, err: ``},
+		{s: `SELECT sum(x) FROM Packetbeat group by time(5m, 1m)`, d: `time(5m, 1m)`, err: ``},
+		{s: `SELECT sum(x) FROM Packetbeat group by tcp.src_ip, session(10m)`, d: `tcp.src_ip, session(10m)`, err: ``},
 	}
 	for i, tt := range tests {
 		p := jepl.NewParser(strings.NewReader(tt.s))
@@ -37,6 +43,66 @@ func TestParseGroupBy(t *testing.T) {
 	}
 }
 
+// Ensure the parser rejects invalid GROUP BY dimensions.
+func TestParseGroupBy_Invalid(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		err string
+	}{
+		{s: `SELECT sum(x) FROM foo group by time(x)`, err: `time dimension must have duration arguments`},
+		{s: `SELECT sum(x) FROM foo group by time(1m, 1m, 1m)`, err: `invalid number of arguments for time, expected 1 to 2, got 3`},
+		{s: `SELECT sum(x) FROM foo group by time(0s)`, err: `time dimension must have positive durations, got 0s`},
+		{s: `SELECT sum(x) FROM foo group by time(250ms)`, err: `time dimension must have durations of at least 1s, got 250ms`},
+		{s: `SELECT sum(x) FROM foo group by time(1m, 500ms)`, err: `time dimension must have durations of at least 1s, got 500ms`},
+		{s: `SELECT sum(x) FROM foo group by session(999ms)`, err: `session dimension must have durations of at least 1s, got 999ms`},
+		{s: `SELECT sum(x) FROM foo group by time(1m, 5m)`, err: `time dimension must hop no further than its size, got time(1m, 5m)`},
+		{s: `SELECT sum(x) FROM foo group by session(1m, 1m)`, err: `invalid number of arguments for session, expected 1 to 1, got 2`},
+		{s: `SELECT sum(x) FROM foo group by session()`, err: `invalid number of arguments for session, expected 1 to 1, got 0`},
+		{s: `SELECT sum(x) FROM foo group by time(1m), time(1h)`, err: `multiple time dimensions not allowed`},
+		{s: `SELECT sum(x) FROM foo group by time(1m), session(1h)`, err: `multiple time dimensions not allowed`},
+		{s: `SELECT sum(x) FROM foo group by max(x)`, err: `invalid dimension max(x), only time() and session() functions allowed in GROUP BY`},
+		{s: `SELECT sum(x) FROM foo group by time(1x)`, err: `unable to parse duration at line 1, char 38`},
+	} {
+		_, err := jepl.ParseStatement(tt.s)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+		}
+	}
+}
+
+// Ensure the GROUP BY window can be extracted.
+func TestSelectStatement_GroupByWindow(t *testing.T) {
+	for i, tt := range []struct {
+		s string
+		w jepl.Window
+	}{
+		{s: `SELECT sum(x) FROM foo group by host`},
+		{s: `SELECT sum(x) FROM foo group by time(1m)`, w: jepl.Window{Size: time.Minute, Every: time.Minute}},
+		{s: `SELECT sum(x) FROM foo group by time(5m, 1m)`, w: jepl.Window{Size: 5 * time.Minute, Every: time.Minute}},
+		{s: `SELECT sum(x) FROM foo group by host, session(30s)`, w: jepl.Window{Gap: 30 * time.Second}},
+	} {
+		if w := MustParseSelectStatement(tt.s).GroupByWindow(); w != tt.w {
+			t.Errorf("%d. %q: window mismatch: exp=%+v got=%+v", i, tt.s, tt.w, w)
+		}
+	}
+}
+
+// Ensure the GROUP BY interval can be extracted.
+func TestSelectStatement_GroupByInterval(t *testing.T) {
+	for i, tt := range []struct {
+		s string
+		d time.Duration
+	}{
+		{s: `SELECT sum(x) FROM foo group by host`},
+		{s: `SELECT sum(x) FROM foo group by time(1m)`, d: time.Minute},
+		{s: `SELECT sum(x) FROM foo group by host, time(1h30m)`, d: 90 * time.Minute},
+	} {
+		if d := MustParseSelectStatement(tt.s).GroupByInterval(); d != tt.d {
+			t.Errorf("%d. %q: interval mismatch: exp=%s got=%s", i, tt.s, tt.d, d)
+		}
+	}
+}
+
 // Ensure the parser can only parse select statement
 func TestParseStatement(t *testing.T) {
 	// For use in various tests.
@@ -73,22 +139,65 @@ func TestParseSelectStatement(t *testing.T) {
 		{s: `SELECT count(max(value)) FROM myseries`, err: `expected only field argument in count()`},
 		{s: `SELECT count(7 * in_bytes) FROM myseries`, err: `expected only field argument in count()`},
 		{s: `SELECT count(value), value FROM foo`, err: `invalid field value in SELECT field, at least one function`},
-		{s: `select count() from myseries`, err: `invalid number of arguments for count, expected 1, got 0`},
+		{s: `select count(x, y) from myseries`, err: `invalid number of arguments for count, expected 0 to 1, got 2`},
+		{s: `select sum() from myseries`, err: `invalid number of arguments for sum, expected 1, got 0`},
+		{s: `select sum(*) from myseries`, err: `* is only supported in count() at line 1, char 12`},
+		{s: `select count(*, x) from myseries`, err: `found ,, expected ) at line 1, char 15`},
 
 		{s: `SELECT value = 2 FROM cpu`, err: `invalid operator = in SELECT field, only support +-*/`},
 		{s: `SELECT s =~ /foo/ FROM cpu`, err: `invalid operator =~ in SELECT field, only support +-*/`},
 		{s: `SELECT count(foo + sum(bar)) FROM cpu`, err: `expected only field argument in count()`},
 		{s: `SELECT (count(foo + sum(bar))) FROM cpu`, err: `expected only field argument in count()`},
 		{s: `SELECT sum(value) + count(foo + sum(bar)) FROM cpu`, err: `binary expressions cannot mix aggregates and raw fields`},
+		{s: `SELECT (sum(x) + y) FROM cpu`, err: `binary expressions cannot mix aggregates and raw fields`},
+		{s: `SELECT ((sum(x) + y)) FROM cpu`, err: `binary expressions cannot mix aggregates and raw fields`},
+		{s: `SELECT (x) FROM cpu`, err: `invalid field (x) in SELECT field, at least one function`},
+		{s: `SELECT sum(DISTINCT x) FROM cpu`, err: `DISTINCT is only supported in count() at line 1, char 12`},
+		{s: `SELECT count(DISTINCT x + 1) FROM cpu`, err: `found +, expected ) at line 1, char 25`},
+		{s: `SELECT count(DISTINCT) FROM cpu`, err: `found ), expected identifier at line 1, char 22`},
+		{s: `SELECT count(x) FILTER WHERE x > 1 FROM cpu`, err: `found WHERE, expected ( at line 1, char 24`},
+		{s: `SELECT count(x) FILTER (x > 1) FROM cpu`, err: `found x, expected WHERE at line 1, char 25`},
+		{s: `SELECT count(x) FILTER (WHERE x > 1 FROM cpu`, err: `found FROM, expected ) at line 1, char 37`},
+		{s: `SELECT count(x) FROM cpu GROUP BY time(1m) FILTER (WHERE x > 1)`, err: `FILTER is only supported by aggregate functions, got time()`},
+		{s: `SELECT count(x) FROM cpu GROUP BY session(1m) FILTER (WHERE x > 1)`, err: `FILTER is only supported by aggregate functions, got session()`},
+		{s: `SELECT count(x) FROM cpu WHERE lower(x) FILTER (WHERE x > 1) = 'a'`, err: `FILTER is only supported by aggregate functions, got lower()`},
+
+		{s: `SELECT sum(x) FROM cpu GROUP BY host HAVING x > 1`, err: `invalid HAVING condition, x is not an aggregate or a field alias`},
+		{s: `SELECT sum(x) FROM cpu HAVING avg(lower(x)) > 1`, err: ``},
+		{s: `SELECT sum(x) FROM cpu HAVING nope(x) > 1`, err: `undefined aggregate function nope()`},
+		{s: `SELECT sum(x) FROM cpu HAVING top(x, 1) > 1`, err: `selector function top() cannot be used in HAVING`},
+		{s: `SELECT sum(x) FROM cpu HAVING`, err: `found EOF, expected identifier, string, number, bool at line 1, char 31`},
+		{s: `SELECT sum(x) FROM cpu HAVING sum(x) > 1 GROUP BY host`, err: `found GROUP, expected EOF at line 1, char 42`},
+
+		{s: `SELECT sum(x) FROM cpu ORDER BY x`, err: `invalid ORDER BY field, x is not an aggregate or a field alias`},
+		{s: `SELECT sum(x) FROM cpu ORDER BY top(x, 1) DESC`, err: `selector function top() cannot be used in ORDER BY`},
+		{s: `SELECT sum(x) FROM cpu ORDER sum(x)`, err: `found sum, expected BY at line 1, char 30`},
+		{s: `SELECT sum(x) FROM cpu LIMIT`, err: `found EOF, expected integer at line 1, char 30`},
+		{s: `SELECT sum(x) FROM cpu LIMIT 1.5`, err: `found 1.5, expected integer at line 1, char 30`},
+		{s: `SELECT sum(x) FROM cpu OFFSET 1 LIMIT 1`, err: `found LIMIT, expected EOF at line 1, char 33`},
+
+		{s: `SELECT NOT sum(x) FROM cpu`, err: `invalid operator NOT in SELECT field, only support +-*/`},
+		{s: `SELECT sum(x) IS NULL FROM cpu`, err: `invalid operator IS in SELECT field, only support +-*/`},
+		{s: `SELECT -x FROM cpu`, err: `invalid field -x in SELECT field, at least one function`},
+		{s: `SELECT sum(x) FROM cpu WHERE exists(x + 1)`, err: `expected only field argument in exists()`},
+		{s: `SELECT sum(x) FROM cpu WHERE -'a' = x`, err: `invalid filter, unsupport op - for string`},
+		{s: `SELECT sum(x) LIKE 'a' FROM cpu`, err: `invalid operator LIKE in SELECT field, only support +-*/`},
 
 		// Correct
 		{s: `SELECT count(x) from foo`, err: ``},
+		{s: `SELECT -sum(x), sum(-x) from foo where NOT exists(y) AND z IS NOT NULL`, err: ``},
+		{s: `SELECT sum(x) AS total, count(*) from foo group by host order by total desc, count(*) limit 10 offset 5`, err: ``},
+		{s: `SELECT sum(x) AS total from foo group by host having total > 1000000000 OR count(*) < 3`, err: ``},
+		{s: `SELECT sum(x) from foo having abs(sum(x) - 10) <= 2`, err: ``},
+		{s: `SELECT count(*), count() from foo`, err: ``},
+		{s: `SELECT sum(1), avg(2 * 3) from foo`, err: ``},
 		{s: `SELECT sum(x) from foo`, err: ``},
 		{s: `SELECT avg(x) from foo`, err: ``},
 		{s: `SELECT count(x), sum(x) from foo`, err: ``},
 		{s: `SELECT count(x), sum(x)+sum(y) from foo`, err: ``},
 		{s: `SELECT sum(x + y *6 /z) from foo`, err: ``},
 		{s: `SELECT sum(x) * (sum(y) / sum(z)) from foo group by host`, err: ``},
+		{s: `SELECT count(DISTINCT tcp.src_ip) from foo group by tcp.dst_ip`, err: ``},
 	}
 	for i, tt := range tests {
 
@@ -115,6 +224,7 @@ func TestParser_ParseExpr(t *testing.T) {
 		{s: `true`, expr: &jepl.BooleanLiteral{Val: true}},
 		{s: `false`, expr: &jepl.BooleanLiteral{Val: false}},
 		{s: `my_ident`, expr: &jepl.VarRef{Val: "my_ident", Segments: []string{"my_ident"}}},
+		{s: `10m`, expr: &jepl.DurationLiteral{Val: 10 * time.Minute}},
 		// Simple binary expression
 		{
 			s: `1 + 2`,
@@ -233,16 +343,325 @@ func TestParser_ParseExpr(t *testing.T) {
 		{
 			s: `my_func()`,
 			expr: &jepl.Call{
-				Name: "my_func", First: true,
+				Name: "my_func",
+			},
+		},
+
+		// Function call (distinct)
+		{
+			s: `count(DISTINCT host)`,
+			expr: &jepl.Call{
+				Name: "distinct_count",
+				Args: []jepl.Expr{&jepl.VarRef{Val: "host", Segments: []string{"host"}}},
+			},
+		},
+
+		// Function call (filter)
+		{
+			s: `count(host) FILTER (WHERE status >= 500)`,
+			expr: &jepl.Call{
+				Name: "count",
+				Args: []jepl.Expr{&jepl.VarRef{Val: "host", Segments: []string{"host"}}},
+				Filter: &jepl.BinaryExpr{
+					Op:  jepl.GTE,
+					LHS: &jepl.VarRef{Val: "status", Segments: []string{"status"}},
+					RHS: &jepl.IntegerLiteral{Val: 500},
+				},
+			},
+		},
+
+		// Signed literals
+		{s: `-100`, expr: &jepl.IntegerLiteral{Val: -100}},
+		{s: `-1.5`, expr: &jepl.NumberLiteral{Val: -1.5}},
+		{s: `+.5`, expr: &jepl.NumberLiteral{Val: 0.5}},
+		{s: `-10m`, expr: &jepl.DurationLiteral{Val: -10 * time.Minute}},
+
+		// Subtraction without whitespace
+		{
+			s: `x-1`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.SUB,
+				LHS: &jepl.VarRef{Val: "x", Segments: []string{"x"}},
+				RHS: &jepl.IntegerLiteral{Val: 1},
+			},
+		},
+
+		// Unary minus binds tighter than multiplication
+		{
+			s: `-x * 2`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.MUL,
+				LHS: &jepl.UnaryExpr{Op: jepl.SUB, Expr: &jepl.VarRef{Val: "x", Segments: []string{"x"}}},
+				RHS: &jepl.IntegerLiteral{Val: 2},
+			},
+		},
+
+		// NOT binds looser than comparisons and tighter than AND
+		{
+			s: `NOT x = 1 AND y`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.AND,
+				LHS: &jepl.UnaryExpr{
+					Op: jepl.NOT,
+					Expr: &jepl.BinaryExpr{
+						Op:  jepl.EQ,
+						LHS: &jepl.VarRef{Val: "x", Segments: []string{"x"}},
+						RHS: &jepl.IntegerLiteral{Val: 1},
+					},
+				},
+				RHS: &jepl.VarRef{Val: "y", Segments: []string{"y"}},
+			},
+		},
+
+		// IS [NOT] NULL
+		{
+			s: `x IS NULL OR y IS NOT NULL`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.OR,
+				LHS: &jepl.BinaryExpr{
+					Op:  jepl.IS,
+					LHS: &jepl.VarRef{Val: "x", Segments: []string{"x"}},
+					RHS: &jepl.NullLiteral{},
+				},
+				RHS: &jepl.BinaryExpr{
+					Op:  jepl.ISNOT,
+					LHS: &jepl.VarRef{Val: "y", Segments: []string{"y"}},
+					RHS: &jepl.NullLiteral{},
+				},
+			},
+		},
+
+		// Signed numbers in lists
+		{
+			s: `x IN [-1, +2.5]`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.IN,
+				LHS: &jepl.VarRef{Val: "x", Segments: []string{"x"}},
+				RHS: &jepl.ListLiteral{Vals: []interface{}{int64(-1), 2.5}},
+			},
+		},
+
+		// Booleans, hexadecimal and exponents in lists
+		{
+			s: `x NI [true, -0x10, 1e3, -2.5e-1, false]`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.NI,
+				LHS: &jepl.VarRef{Val: "x", Segments: []string{"x"}},
+				RHS: &jepl.ListLiteral{Vals: []interface{}{true, int64(-16), 1000.0, -0.25, false}},
+			},
+		},
+
+		// Minus between operands is always binary
+		{
+			s: `a -1`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.SUB,
+				LHS: &jepl.VarRef{Val: "a", Segments: []string{"a"}},
+				RHS: &jepl.IntegerLiteral{Val: 1},
+			},
+		},
+		{
+			s: `a--1e2`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.SUB,
+				LHS: &jepl.VarRef{Val: "a", Segments: []string{"a"}},
+				RHS: &jepl.NumberLiteral{Val: -100},
+			},
+		},
+		{
+			s: `0x1F - -0xa`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.SUB,
+				LHS: &jepl.IntegerLiteral{Val: 31},
+				RHS: &jepl.IntegerLiteral{Val: -10},
+			},
+		},
+		{s: `0x8000000000000000`, err: `unable to parse integer at line 1, char 1`},
+
+		// BETWEEN keeps its bounds as an AND
+		{
+			s: `x BETWEEN 1 AND y + 2 AND z`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.AND,
+				LHS: &jepl.BinaryExpr{
+					Op:  jepl.BETWEEN,
+					LHS: &jepl.VarRef{Val: "x", Segments: []string{"x"}},
+					RHS: &jepl.BinaryExpr{
+						Op:  jepl.AND,
+						LHS: &jepl.IntegerLiteral{Val: 1},
+						RHS: &jepl.BinaryExpr{
+							Op:  jepl.ADD,
+							LHS: &jepl.VarRef{Val: "y", Segments: []string{"y"}},
+							RHS: &jepl.IntegerLiteral{Val: 2},
+						},
+					},
+				},
+				RHS: &jepl.VarRef{Val: "z", Segments: []string{"z"}},
+			},
+		},
+
+		// String operators
+		{
+			s: `host STARTS WITH 'web' OR host ILIKE '%db%'`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.OR,
+				LHS: &jepl.BinaryExpr{
+					Op:  jepl.STARTSWITH,
+					LHS: &jepl.VarRef{Val: "host", Segments: []string{"host"}},
+					RHS: &jepl.StringLiteral{Val: "web"},
+				},
+				RHS: &jepl.BinaryExpr{
+					Op:  jepl.ILIKE,
+					LHS: &jepl.VarRef{Val: "host", Segments: []string{"host"}},
+					RHS: &jepl.StringLiteral{Val: "%db%"},
+				},
+			},
+		},
+
+		// Times
+		{
+			s: `@timestamp > now() - 15m AND t BETWEEN '2016-12-14T16:00:00Z' AND t2 AND m = '2016-12-14'`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.AND,
+				LHS: &jepl.BinaryExpr{
+					Op: jepl.AND,
+					LHS: &jepl.BinaryExpr{
+						Op:  jepl.GT,
+						LHS: &jepl.VarRef{Val: "@timestamp", Segments: []string{"@timestamp"}},
+						RHS: &jepl.BinaryExpr{
+							Op:  jepl.SUB,
+							LHS: &jepl.Call{Name: "now"},
+							RHS: &jepl.DurationLiteral{Val: 15 * time.Minute},
+						},
+					},
+					RHS: &jepl.BinaryExpr{
+						Op:  jepl.BETWEEN,
+						LHS: &jepl.VarRef{Val: "t", Segments: []string{"t"}},
+						RHS: &jepl.BinaryExpr{
+							Op:  jepl.AND,
+							LHS: &jepl.TimeLiteral{Val: time.Date(2016, 12, 14, 16, 0, 0, 0, time.UTC), Raw: "2016-12-14T16:00:00Z"},
+							RHS: &jepl.VarRef{Val: "t2", Segments: []string{"t2"}},
+						},
+					},
+				},
+				RHS: &jepl.BinaryExpr{
+					Op:  jepl.EQ,
+					LHS: &jepl.VarRef{Val: "m", Segments: []string{"m"}},
+					RHS: &jepl.StringLiteral{Val: "2016-12-14"},
+				},
+			},
+		},
+		{
+			s: `t LIKE '2016-12-14T16:00:00Z'`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.LIKE,
+				LHS: &jepl.VarRef{Val: "t", Segments: []string{"t"}},
+				RHS: &jepl.StringLiteral{Val: "2016-12-14T16:00:00Z"},
+			},
+		},
+
+		// Quoted identifiers
+		{
+			s: "`@metadata`.`with.dot`.`user-agent` = `select`",
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.EQ,
+				LHS: &jepl.VarRef{Val: "@metadata.with.dot.user-agent", Segments: []string{"@metadata", "with.dot", "user-agent"}},
+				RHS: &jepl.VarRef{Val: "select", Segments: []string{"select"}},
+			},
+		},
+		{s: "`user-agent", err: `found user-agent, expected identifier, string, number, bool at line 1, char 1`},
+
+		// Array indexes and quantifiers
+		{
+			s: `ANY(dns.answers[*].data) = ALL(tags[0])`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.EQ,
+				LHS: &jepl.Call{
+					Name: "any",
+					Args: []jepl.Expr{&jepl.VarRef{Val: "dns.answers[*].data", Segments: []string{"dns", "answers", "[*]", "data"}}},
+				},
+				RHS: &jepl.Call{
+					Name: "all",
+					Args: []jepl.Expr{&jepl.VarRef{Val: "tags[0]", Segments: []string{"tags", "[0]"}}},
+				},
+			},
+		},
+		{s: `tags[x]`, err: `found x, expected integer, * at line 1, char 6`},
+		{s: `tags[0`, err: `found EOF, expected ] at line 1, char 7`},
+		{s: `tags[0].`, err: `found EOF, expected identifier at line 1, char 9`},
+		{s: `ANY tags`, err: `found  , expected ( at line 1, char 4`},
+
+		// IP and CIDR literals
+		{
+			s: `ip = IP('::1') AND ip IN CIDR('10.1.2.3/8')`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.AND,
+				LHS: &jepl.BinaryExpr{
+					Op:  jepl.EQ,
+					LHS: &jepl.VarRef{Val: "ip", Segments: []string{"ip"}},
+					RHS: &jepl.IPLiteral{Val: net.ParseIP("::1")},
+				},
+				RHS: &jepl.BinaryExpr{
+					Op:  jepl.IN,
+					LHS: &jepl.VarRef{Val: "ip", Segments: []string{"ip"}},
+					RHS: &jepl.CIDRLiteral{Val: mustParseCIDR("10.0.0.0/8")},
+				},
+			},
+		},
+		{
+			s: `ip NI [cidr('fc00::/7'), ip('127.0.0.1'), 'x']`,
+			expr: &jepl.BinaryExpr{
+				Op:  jepl.NI,
+				LHS: &jepl.VarRef{Val: "ip", Segments: []string{"ip"}},
+				RHS: &jepl.ListLiteral{Vals: []interface{}{mustParseCIDR("fc00::/7"), net.ParseIP("127.0.0.1"), "x"}},
+			},
+		},
+		{s: `ip = IP('10.0.0.256')`, err: `invalid IP address '10.0.0.256' at line 1, char 8`},
+		{s: `ip IN CIDR('10.0.0.0')`, err: `invalid CIDR address '10.0.0.0' at line 1, char 11`},
+		{s: `ip IN CIDR(ip)`, err: `found ip, expected string at line 1, char 12`},
+		{s: `ip IN [host]`, err: `found host, expected string, float, integer, bool, IP, CIDR at line 1, char 8`},
+
+		// Bound parameters
+		{
+			s: `host IN $hosts AND url =~ $pattern AND bytes > $min`,
+			expr: &jepl.BinaryExpr{
+				Op: jepl.AND,
+				LHS: &jepl.BinaryExpr{
+					Op: jepl.AND,
+					LHS: &jepl.BinaryExpr{
+						Op:  jepl.IN,
+						LHS: &jepl.VarRef{Val: "host", Segments: []string{"host"}},
+						RHS: &jepl.BoundParameter{Name: "hosts"},
+					},
+					RHS: &jepl.BinaryExpr{
+						Op:  jepl.EQREGEX,
+						LHS: &jepl.VarRef{Val: "url", Segments: []string{"url"}},
+						RHS: &jepl.BoundParameter{Name: "pattern"},
+					},
+				},
+				RHS: &jepl.BinaryExpr{
+					Op:  jepl.GT,
+					LHS: &jepl.VarRef{Val: "bytes", Segments: []string{"bytes"}},
+					RHS: &jepl.BoundParameter{Name: "min"},
+				},
 			},
 		},
+		{s: `url =~ $`, err: `found $, expected regex at line 1, char 8`},
+		{s: `host IN $`, err: `found $, expected [ at line 1, char 9`},
+
+		{s: `x BETWEEN 1 5`, err: `found 5, expected AND at line 1, char 13`},
+		{s: `x BETWEEN 1 OR 5`, err: `found OR, expected AND at line 1, char 13`},
+		{s: `x STARTS 'a'`, err: `found a, expected WITH at line 1, char 9`},
+		{s: `x IS 1`, err: `found 1, expected NULL at line 1, char 6`},
+		{s: `x IS NOT`, err: `found EOF, expected NULL at line 1, char 10`},
+		{s: `x IN [-'a']`, err: `found a, expected float, integer at line 1, char 7`},
+		{s: `NULL`, err: `found NULL, expected identifier, string, number, bool at line 1, char 1`},
 
 		// Function call (multi-arg)
 		{
 			s: `my_func(1, 2 + 3)`,
 			expr: &jepl.Call{
-				Name:  "my_func",
-				First: true,
+				Name: "my_func",
 				Args: []jepl.Expr{
 					&jepl.IntegerLiteral{Val: 1},
 					&jepl.BinaryExpr{
@@ -265,6 +684,31 @@ func TestParser_ParseExpr(t *testing.T) {
 	}
 }
 
+// Ensure numbers format in full and scan back as the same literals.
+func TestNumberLiteral_String(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		exp string
+	}{
+		{s: `v < 1e-9`, exp: `v < 1e-09`},
+		{s: `v < 0.5`, exp: `v < 0.5`},
+		{s: `v < 2.0`, exp: `v < 2.0`},
+		{s: `v < 1e3`, exp: `v < 1000.0`},
+		{s: `v < 1.5e21`, exp: `v < 1.5e+21`},
+		{s: `v < 0.0001234`, exp: `v < 0.0001234`},
+		{s: `v IN [1e-9, 2.0, 3]`, exp: `v IN [1e-09, 2.0, 3]`},
+	} {
+		expr := MustParseExpr(tt.s)
+		if got := expr.String(); got != tt.exp {
+			t.Errorf("%d. %q: string mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.exp, got)
+			continue
+		}
+		if other := MustParseExpr(expr.String()); !reflect.DeepEqual(expr, other) {
+			t.Errorf("%d. %q: round trip mismatch:\n  exp=%#v\n  got=%#v", i, tt.s, expr, other)
+		}
+	}
+}
+
 // Ensure a string can be quoted.
 func TestQuote(t *testing.T) {
 	for i, tt := range []struct {
@@ -289,15 +733,16 @@ func TestQuoteIdent(t *testing.T) {
 		ident []string
 		s     string
 	}{
-		{[]string{``}, ``},
-		{[]string{`select`}, `"select"`},
-		{[]string{`in-bytes`}, `"in-bytes"`},
-		{[]string{`foo`, `bar`}, `"foo".bar`},
-		{[]string{`foo`, ``, `bar`}, `"foo"..bar`},
-		{[]string{`foo bar`, `baz`}, `"foo bar".baz`},
-		{[]string{`foo.bar`, `baz`}, `"foo.bar".baz`},
-		{[]string{`foo.bar`, `rp`, `baz`}, `"foo.bar"."rp".baz`},
-		{[]string{`foo.bar`, `rp`, `1baz`}, `"foo.bar"."rp"."1baz"`},
+		{[]string{``}, "``"},
+		{[]string{`select`}, "`select`"},
+		{[]string{`in-bytes`}, "`in-bytes`"},
+		{[]string{`@timestamp`}, `@timestamp`},
+		{[]string{`foo`, `bar`}, `foo.bar`},
+		{[]string{`foo`, ``, `bar`}, "foo.``.bar"},
+		{[]string{`foo bar`, `baz`}, "`foo bar`.baz"},
+		{[]string{`foo.bar`, `baz`}, "`foo.bar`.baz"},
+		{[]string{`foo.bar`, `rp`, `1baz`}, "`foo.bar`.rp.`1baz`"},
+		{[]string{"a`b\\c"}, "`a\\`b\\\\c`"},
 	} {
 		if s := jepl.QuoteIdent(tt.ident...); tt.s != s {
 			t.Errorf("%d. %s: mismatch: %s != %s", i, tt.ident, tt.s, s)
@@ -324,6 +769,15 @@ func MustParseExpr(s string) jepl.Expr {
 	return expr
 }
 
+// mustParseCIDR parses a CIDR address into its network.
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipnet
+}
+
 // errstring converts an error to its string representation.
 func errstring(err error) string {
 	if err != nil {
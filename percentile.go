@@ -0,0 +1,250 @@
+package jepl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultCompression is the t-digest compression used by percentile() and
+// median() unless given as their last argument. Higher values trade memory
+// for accuracy; a digest keeps on the order of 2 * compression centroids.
+const DefaultCompression = 100
+
+// exactPercentileLimit is the number of values a percentile keeps exactly
+// before summarizing them in a t-digest.
+const exactPercentileLimit = 1000
+
+func init() {
+	RegisterAggregate("percentile", &Aggregate{
+		Args:     []ArgType{ExprArg, NumberArg, NumberArg},
+		Optional: 1,
+		Validate: validatePercentile,
+		New: func(args []Expr) Accumulator {
+			return newPercentileAccumulator(literalNumber(args[1]), compressionArg(args, 2))
+		},
+	})
+	RegisterAggregate("median", &Aggregate{
+		Args:     []ArgType{ExprArg, NumberArg},
+		Optional: 1,
+		Validate: validatePercentile,
+		New: func(args []Expr) Accumulator {
+			return newPercentileAccumulator(50, compressionArg(args, 1))
+		},
+	})
+}
+
+// validatePercentile checks the percentile and compression of a call.
+func validatePercentile(c *Call) error {
+	i := 1
+	if c.Name == "percentile" {
+		if n := literalNumber(c.Args[1]); n < 0 || n > 100 {
+			return fmt.Errorf("percentile must be between 0 and 100, got %s", c.Args[1])
+		}
+		i = 2
+	}
+	if len(c.Args) > i {
+		if n := literalNumber(c.Args[i]); n < 1 {
+			return fmt.Errorf("compression must be at least 1, got %s", c.Args[i])
+		}
+	}
+	return nil
+}
+
+// literalNumber returns the value of a numeric literal.
+func literalNumber(expr Expr) float64 {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return expr.Val
+	case *IntegerLiteral:
+		return float64(expr.Val)
+	}
+	return 0
+}
+
+// compressionArg returns the compression given as argument i, if any.
+func compressionArg(args []Expr, i int) float64 {
+	if len(args) > i {
+		return literalNumber(args[i])
+	}
+	return DefaultCompression
+}
+
+// percentileAccumulator keeps values exactly up to exactPercentileLimit,
+// then folds them into a t-digest so memory stays bounded.
+type percentileAccumulator struct {
+	p           float64
+	compression float64
+	values      []float64
+	digest      *tdigest
+}
+
+func newPercentileAccumulator(p, compression float64) *percentileAccumulator {
+	return &percentileAccumulator{p: p, compression: compression}
+}
+
+func (a *percentileAccumulator) Add(ts int64, args []interface{}) {
+	if v, ok := number(args[0]); ok {
+		a.add(v, 1)
+	}
+}
+
+func (a *percentileAccumulator) add(v, count float64) {
+	if a.digest != nil {
+		a.digest.add(v, count)
+		return
+	}
+	a.values = append(a.values, v)
+	if len(a.values) > exactPercentileLimit {
+		a.digest = newTDigest(a.compression)
+		for _, v := range a.values {
+			a.digest.add(v, 1)
+		}
+		a.values = nil
+	}
+}
+
+func (a *percentileAccumulator) Merge(other Accumulator) {
+	o := other.(*percentileAccumulator)
+	for _, v := range o.values {
+		a.add(v, 1)
+	}
+	if o.digest == nil {
+		return
+	}
+	if a.digest == nil {
+		a.digest = newTDigest(a.compression)
+		for _, v := range a.values {
+			a.digest.add(v, 1)
+		}
+		a.values = nil
+	}
+	a.digest.merge(o.digest)
+}
+
+func (a *percentileAccumulator) Result() interface{} {
+	if a.digest != nil {
+		return a.digest.quantile(a.p / 100)
+	}
+	if len(a.values) == 0 {
+		return nil
+	}
+
+	// Interpolate between the closest ranks.
+	sort.Float64s(a.values)
+	rank := a.p / 100 * float64(len(a.values)-1)
+	i := int(rank)
+	if i == len(a.values)-1 {
+		return a.values[i]
+	}
+	return a.values[i] + (a.values[i+1]-a.values[i])*(rank-float64(i))
+}
+
+// centroid is the mean of count values of a t-digest.
+type centroid struct {
+	mean, count float64
+}
+
+// tdigest is a merging t-digest, a sketch of a distribution whose quantile
+// estimates are most accurate at the tails. See Dunning and Ertl,
+// "Computing Extremely Accurate Quantiles Using t-Digests".
+type tdigest struct {
+	compression float64
+	centroids   []centroid // merged, sorted by mean
+	buf         []centroid // added since the last compress
+	count       float64
+	min, max    float64
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// add folds count occurrences of v into the digest.
+func (d *tdigest) add(v, count float64) {
+	d.buf = append(d.buf, centroid{mean: v, count: count})
+	d.count += count
+	if v < d.min {
+		d.min = v
+	}
+	if v > d.max {
+		d.max = v
+	}
+	if len(d.buf) > int(5*d.compression) {
+		d.compress()
+	}
+}
+
+// merge folds the centroids of other into the digest.
+func (d *tdigest) merge(other *tdigest) {
+	for _, c := range other.centroids {
+		d.add(c.mean, c.count)
+	}
+	for _, c := range other.buf {
+		d.add(c.mean, c.count)
+	}
+}
+
+// compress merges the buffered values into the centroids. Neighbouring
+// centroids are combined while they span less than one unit of the scale
+// function k(q) = compression / 2π * asin(2q - 1), which keeps centroids
+// small near the tails.
+func (d *tdigest) compress() {
+	if len(d.buf) == 0 {
+		return
+	}
+	cs := append(d.centroids, d.buf...)
+	sort.Slice(cs, func(i, j int) bool { return cs[i].mean < cs[j].mean })
+
+	k := func(q float64) float64 { return d.compression / (2 * math.Pi) * math.Asin(2*q-1) }
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	cur := cs[0]
+	var cum float64 // weight of the centroids before cur
+	kLeft := k(0)
+	for _, c := range cs[1:] {
+		if k((cum+cur.count+c.count)/d.count)-kLeft <= 1 {
+			cur.mean += (c.mean - cur.mean) * c.count / (cur.count + c.count)
+			cur.count += c.count
+			continue
+		}
+		merged = append(merged, cur)
+		cum += cur.count
+		kLeft = k(cum / d.count)
+		cur = c
+	}
+	d.centroids = append(merged, cur)
+	d.buf = d.buf[:0]
+}
+
+// quantile estimates the value at quantile q, interpolating between the
+// centres of the centroids.
+func (d *tdigest) quantile(q float64) float64 {
+	d.compress()
+	cs := d.centroids
+	if len(cs) == 0 {
+		return math.NaN()
+	} else if len(cs) == 1 {
+		return cs[0].mean
+	}
+
+	index := q * d.count
+	if half := cs[0].count / 2; index < half {
+		return d.min + (cs[0].mean-d.min)*index/half
+	}
+
+	cum := cs[0].count / 2
+	for i := 0; i < len(cs)-1; i++ {
+		dw := (cs[i].count + cs[i+1].count) / 2
+		if cum+dw > index {
+			return cs[i].mean + (cs[i+1].mean-cs[i].mean)*(index-cum)/dw
+		}
+		cum += dw
+	}
+
+	last := cs[len(cs)-1]
+	if index >= d.count {
+		return d.max
+	}
+	return last.mean + (d.max-last.mean)*(index-cum)/(last.count/2)
+}
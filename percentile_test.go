@@ -0,0 +1,89 @@
+package jepl_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure percentiles are exact for small inputs.
+func TestPercentile_Exact(t *testing.T) {
+	docs := []string{`{"x": 15}`, `{"x": 20}`, `{"x": 35}`, `{"x": 40}`, `{"x": 50}`, `{"y": 1}`}
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+		err string
+	}{
+		{s: `select median(x) from foo`, exp: []float64{35}},
+		{s: `select percentile(x, 0), percentile(x, 100) from foo`, exp: []float64{15, 50}},
+		{s: `select percentile(x, 40), percentile(x, 62.5) from foo`, exp: []float64{29, 37.5}},
+		{s: `select median(y) from foo`, exp: []float64{1}},
+		{s: `select median(z) from foo`, exp: []float64{0}},
+		{s: `select percentile(x) from foo`, err: `invalid number of arguments for percentile, expected 2 to 3, got 1`},
+		{s: `select percentile(x, y) from foo`, err: `expected number argument in percentile()`},
+		{s: `select percentile(x, 101) from foo`, err: `percentile must be between 0 and 100, got 101`},
+		{s: `select median(x, 0) from foo`, err: `compression must be at least 1, got 0`},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		var got []float64
+		for _, p := range res.Get("").Points {
+			got = append(got, p.Metric)
+		}
+		if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: metrics mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure percentiles of large inputs are estimated within bounds,
+// including across merged sessions.
+func TestPercentile_Digest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var docs []string
+	var values []float64
+	for i := 0; i < 10000; i++ {
+		// Two sessions, bridged by the last event.
+		v := rnd.Float64() * 1000
+		docs = append(docs, fmt.Sprintf(`{"@timestamp": %d, "x": %v}`, i%2*100, v))
+		values = append(values, v)
+	}
+	docs = append(docs, `{"@timestamp": 50, "x": 500}`)
+	values = append(values, 500)
+	sort.Float64s(values)
+	exact := func(p float64) float64 { return values[int(p/100*float64(len(values)-1))] }
+
+	for i, tt := range []struct {
+		s   string
+		exp []float64
+	}{
+		{s: `select median(x), percentile(x, 99), percentile(x, 1) from foo`, exp: []float64{exact(50), exact(99), exact(1)}},
+		{s: `select median(x, 500), percentile(x, 99.9, 500) from foo group by session(1m)`, exp: []float64{exact(50), exact(99.9)}},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		ps := res.Get("").Points
+		if len(ps) != len(tt.exp) {
+			t.Fatalf("%d. %q: unexpected points: %v", i, tt.s, ps)
+		}
+		for j, p := range ps {
+			if math.Abs(p.Metric-tt.exp[j]) > 5 {
+				t.Errorf("%d. %q: column %d: exp~%v got=%v", i, tt.s, j, tt.exp[j], p.Metric)
+			}
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package jepl
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+// DefaultTimeField is the document field holding the event timestamp.
+const DefaultTimeField = "@timestamp"
+
+var (
+	// ErrLateEvent is returned by Push for an event behind the watermark
+	// whose windows are all closed.
+	ErrLateEvent = errors.New("event arrived after its windows closed")
+
+	// ErrMissingTime is wrapped in the *EvalError returned by Push for
+	// an event without the time field needed by a time dimension.
+	ErrMissingTime = errors.New("missing time field")
+)
+
+// Query evaluates a select statement over a stream of json documents.
+// Aggregate state is kept per group as documents are pushed, so the
+// documents never have to be held in memory.
+type Query struct {
+	// TimeField is the dotted path of the event timestamp used by
+	// GROUP BY time() and session(), and by aggregates ordered by event
+	// time such as first() and last(). The value may be epoch seconds,
+	// epoch milliseconds or an RFC3339 string. Without a time dimension,
+	// an event lacking a valid timestamp is ordered as if it happened at
+	// the latest event time seen.
+	TimeField string
+
+	// AllowedLateness is how far an event may lag behind the latest event
+	// time seen and still be counted. The latest event time minus the
+	// lateness is the watermark: windows ending before it are closed and
+	// returned by Emit. A negative value, the default, disables the watermark.
+	AllowedLateness time.Duration
+
+	// Valuer supplies the value of now() to the statement, e.g. a *NowValuer
+	// for deterministic results. If nil, now() is the current time.
+	Valuer Valuer
+
+	stmt      *SelectStatement
+	columns   []string
+	dims      []*VarRef
+	window    Window
+	having    Expr   // HAVING clause with field aliases resolved
+	sort      []Expr // ORDER BY expressions with field aliases resolved
+	eventTime bool   // whether an aggregate needs the event time
+	groups    map[string]*group
+	maxTS     int64 // latest event time seen, unix nanoseconds
+	n         int   // number of documents pushed
+}
+
+// group holds the aggregate state of a group, one window per time bucket.
+type group struct {
+	tags    map[string]interface{}
+	windows map[int64]*window // keyed by window start
+}
+
+// window holds the accumulators of a group for a single time window.
+type window struct {
+	start, end int64 // event time range in unix nanoseconds, end exclusive
+	aggs       aggregates
+}
+
+// NewQuery parses sql and returns a Query ready to receive documents.
+// A *ParseError or *UnsupportedStatementError is returned if sql cannot be evaluated.
+// Statements with $name parameters are rejected with a *BindError: bind
+// them with Bind and evaluate the result with NewSelectQuery instead.
+func NewQuery(sql string) (*Query, error) {
+	stmt, err := ParseStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	selectStmt, ok := stmt.(*SelectStatement)
+	if !ok {
+		return nil, &UnsupportedStatementError{Stmt: stmt}
+	}
+	if p := selectStmt.firstParameter(); p != nil {
+		return nil, newBindError(p, "missing parameter %s", p)
+	}
+	return NewSelectQuery(selectStmt), nil
+}
+
+// NewSelectQuery returns a Query evaluating an already parsed statement.
+// The statement is not modified, so it may be cached and shared by
+// queries running concurrently.
+func NewSelectQuery(stmt *SelectStatement) *Query {
+	q := &Query{
+		TimeField:       DefaultTimeField,
+		AllowedLateness: -1,
+		stmt:            stmt,
+		columns:         stmt.ColumnNames(),
+		dims:            stmt.fieldDimensions(),
+		window:          stmt.GroupByWindow(),
+		having:          stmt.havingCondition(),
+		sort:            stmt.sortExprs(),
+		eventTime:       stmt.usesEventTime(),
+	}
+	q.reset()
+	return q
+}
+
+// Statement returns the select statement evaluated by the query.
+func (q *Query) Statement() *SelectStatement { return q.stmt }
+
+// Push folds a single document into the aggregate state of its group.
+// An *EvalError is returned if the document could not be evaluated, and
+// ErrLateEvent if it arrived behind the watermark. Either way the document
+// is skipped and the query remains usable.
+func (q *Query) Push(doc []byte) error {
+	js := string(doc)
+	q.n++
+
+	if ok, err := q.stmt.match(&js, q.Valuer); err != nil {
+		err.Doc = q.n - 1
+		return err
+	} else if !ok {
+		return nil
+	}
+
+	var ts int64
+	if q.window != (Window{}) || q.eventTime {
+		t, err := q.timestamp(doc)
+		switch {
+		case err == nil:
+			ts = t.UnixNano()
+			if ts > q.maxTS {
+				q.maxTS = ts
+			}
+		case q.window == (Window{}):
+			// Only the ordering of first() and last() needs the time,
+			// so keep the event for the other aggregates.
+			ts = q.maxTS
+		default:
+			return &EvalError{Doc: q.n - 1, Message: err.Error(), Err: err}
+		}
+	}
+
+	g := q.group(&js)
+	if g == nil {
+		return nil
+	}
+	if q.window == (Window{}) {
+		w, ok := g.windows[0]
+		if !ok {
+			w = q.newWindow(g, 0, 0)
+		}
+		w.aggs.add(ts, &js, q.Valuer)
+		return nil
+	}
+	if q.window.Gap > 0 {
+		return q.pushSession(g, ts, &js)
+	}
+
+	size, every := int64(q.window.Size), int64(q.window.Every)
+	late := true
+	for start := truncate(ts, every); start > ts-size; start -= every {
+		if q.closed(start + size) {
+			continue
+		}
+		late = false
+
+		w, ok := g.windows[start]
+		if !ok {
+			w = q.newWindow(g, start, start+size)
+		}
+		w.aggs.add(ts, &js, q.Valuer)
+	}
+	if late {
+		return ErrLateEvent
+	}
+	return nil
+}
+
+// pushSession folds js into the session of g it falls in. An event bridging
+// several sessions merges them; one not within the gap of any starts a new one.
+func (q *Query) pushSession(g *group, ts int64, js *string) error {
+	start, end := ts, ts+int64(q.window.Gap)
+
+	var sessions []*window
+	for _, w := range g.windows {
+		if start < w.end && end > w.start {
+			sessions = append(sessions, w)
+		}
+	}
+	if len(sessions) == 0 {
+		if q.closed(end) {
+			return ErrLateEvent
+		}
+		q.newWindow(g, start, end).aggs.add(ts, js, q.Valuer)
+		return nil
+	}
+
+	// Extend the earliest session in place, merging the others into it
+	// in time order so that ties resolve the same way on every run.
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].start < sessions[j].start })
+	w := sessions[0]
+	delete(g.windows, w.start)
+	for _, s := range sessions {
+		if s.start < start {
+			start = s.start
+		}
+		if s.end > end {
+			end = s.end
+		}
+		if s != w {
+			delete(g.windows, s.start)
+			w.aggs.merge(s.aggs)
+		}
+	}
+	w.start, w.end = start, end
+	g.windows[start] = w
+
+	w.aggs.add(ts, js, q.Valuer)
+	return nil
+}
+
+// closed returns true if a window ending at end is behind the watermark.
+func (q *Query) closed(end int64) bool {
+	if q.AllowedLateness < 0 || q.maxTS == math.MinInt64 {
+		return false
+	}
+	return end <= q.maxTS-int64(q.AllowedLateness)
+}
+
+// group returns the group js belongs to, creating it if needed. The group
+// is looked up by the key of the document's dimension values, so each
+// document is evaluated once regardless of the number of groups.
+// Documents missing a dimension value belong to no group.
+func (q *Query) group(js *string) *group {
+	if len(q.dims) == 0 {
+		return q.groups[""]
+	}
+
+	tags := make(map[string]interface{}, len(q.dims))
+	for _, ref := range q.dims {
+		v := Eval(ref, js)
+		switch v.(type) {
+		case nil, []interface{}:
+			// Arrays are not grouped by, like missing fields.
+			return nil
+		}
+		tags[ref.Val] = v
+	}
+
+	k := seriesKey(tags)
+	g, ok := q.groups[k]
+	if !ok {
+		g = &group{tags: tags, windows: make(map[int64]*window)}
+		q.groups[k] = g
+	}
+	return g
+}
+
+// newWindow adds an empty window to g.
+func (q *Query) newWindow(g *group, start, end int64) *window {
+	w := &window{start: start, end: end, aggs: newAggregates(q.stmt)}
+	g.windows[start] = w
+	return w
+}
+
+// timestamp extracts the event time of doc from the TimeField.
+func (q *Query) timestamp(doc []byte) (time.Time, error) {
+	val, dt, _, err := jsonparser.Get(doc, strings.Split(q.TimeField, ".")...)
+	if err == jsonparser.KeyPathNotFoundError || dt == jsonparser.Null {
+		return time.Time{}, ErrMissingTime
+	} else if err != nil {
+		return time.Time{}, ErrInvalidTime
+	}
+	switch dt {
+	case jsonparser.Number, jsonparser.String:
+		if ts, ok := toTime(jsonValue(val, dt)); ok {
+			return ts.UTC(), nil
+		}
+	}
+	return time.Time{}, ErrInvalidTime
+}
+
+// Emit returns the series of the windows closed by the watermark
+// and drops their state. Open windows keep accumulating.
+func (q *Query) Emit() Result {
+	return q.collect(func(w *window) bool { return q.window != (Window{}) && q.closed(w.end) })
+}
+
+// Flush returns the series of every group and resets the aggregate state.
+// With a time dimension each window contributes its points, in time order,
+// stamped with the window start. Series are sorted by the ORDER BY clause,
+// or by key without one.
+func (q *Query) Flush() Result {
+	res := q.collect(func(*window) bool { return true })
+	q.reset()
+	return res
+}
+
+// collect evaluates and removes the windows selected by fn. Windows failing
+// the HAVING clause are dropped, and groups left without windows are
+// released.
+//
+// ORDER BY, LIMIT and OFFSET rank the groups of each time window. Session
+// windows, and statements without a time dimension, are ranked together.
+// Series are returned in the order of the first window they appear in,
+// then by rank.
+func (q *Query) collect(fn func(*window) bool) Result {
+	now := time.Now().Unix()
+
+	buckets := make(map[int64][]*output)
+	for key, g := range q.groups {
+		for start, w := range g.windows {
+			if !fn(w) {
+				continue
+			}
+			delete(g.windows, start)
+
+			vals := w.aggs.values()
+			if q.having != nil {
+				if ok, _ := eval(q.having, nil, q.Valuer, vals).(bool); !ok {
+					continue
+				}
+			}
+			var b int64
+			if q.window.Size > 0 {
+				b = start
+			}
+			buckets[b] = append(buckets[b], q.newOutput(key, g, w, vals))
+		}
+		// Without dimensions the single group is kept for the next event.
+		if len(g.windows) == 0 && len(q.dims) > 0 {
+			delete(q.groups, key)
+		}
+	}
+
+	starts := make([]int64, 0, len(buckets))
+	for b := range buckets {
+		starts = append(starts, b)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var res Result
+	series := make(map[*group]*Series)
+	for _, b := range starts {
+		for _, o := range q.rank(buckets[b]) {
+			s, ok := series[o.g]
+			if !ok {
+				s = &Series{Tags: o.g.tags, Columns: q.columns}
+				series[o.g] = s
+				res = append(res, s)
+			}
+			ts := now
+			if q.window != (Window{}) {
+				ts = time.Unix(0, o.w.start).Unix()
+			}
+			s.Rows = append(s.Rows, q.stmt.evalRows(o.vals, ts, q.Valuer)...)
+		}
+	}
+	for _, s := range res {
+		sort.SliceStable(s.Rows, func(i, j int) bool { return s.Rows[i].TS < s.Rows[j].TS })
+		s.Points = rowPoints(s.Rows)
+	}
+	return res
+}
+
+// output is a window of a group to be returned, with its aggregate values
+// and the values it is sorted by.
+type output struct {
+	key  string
+	g    *group
+	w    *window
+	vals map[*Call]interface{}
+	sort []interface{}
+}
+
+func (q *Query) newOutput(key string, g *group, w *window, vals map[*Call]interface{}) *output {
+	o := &output{key: key, g: g, w: w, vals: vals}
+	if len(q.sort) > 0 {
+		o.sort = make([]interface{}, len(q.sort))
+		for i, expr := range q.sort {
+			o.sort[i] = eval(expr, nil, q.Valuer, vals)
+		}
+	}
+	return o
+}
+
+// rank sorts the outputs of a window by the ORDER BY clause, then by
+// series key and window start, and applies OFFSET and LIMIT.
+func (q *Query) rank(a []*output) []*output {
+	sort.Slice(a, func(i, j int) bool {
+		for k, field := range q.stmt.SortFields {
+			if c := compareSortValues(a[i].sort[k], a[j].sort[k], field.Ascending); c != 0 {
+				return c < 0
+			}
+		}
+		if a[i].key != a[j].key {
+			return a[i].key < a[j].key
+		}
+		return a[i].w.start < a[j].w.start
+	})
+
+	if q.stmt.Offset >= len(a) {
+		return nil
+	}
+	a = a[q.stmt.Offset:]
+	if q.stmt.Limit > 0 && q.stmt.Limit < len(a) {
+		a = a[:q.stmt.Limit]
+	}
+	return a
+}
+
+// compareSortValues compares two values in the given order. Numbers sort
+// before strings and strings before booleans; missing values sort last
+// either way.
+func compareSortValues(a, b interface{}, ascending bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	c := compareValues(a, b)
+	if !ascending {
+		c = -c
+	}
+	return c
+}
+
+// compareValues compares two non-nil values.
+func compareValues(a, b interface{}) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		switch b := b.(bool); {
+		case a == b:
+			return 0
+		case b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	x, _ := number(a)
+	y, _ := number(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// typeRank orders the types of values compared by compareValues.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case float64, int64:
+		return 0
+	case string:
+		return 1
+	case bool:
+		return 2
+	}
+	return 3
+}
+
+// reset drops all group state. A statement without field dimensions always
+// has exactly one group, so it is created up front.
+func (q *Query) reset() {
+	q.groups = make(map[string]*group)
+	q.maxTS = math.MinInt64
+	if len(q.dims) == 0 {
+		g := &group{tags: map[string]interface{}{}, windows: make(map[int64]*window)}
+		if q.window == (Window{}) {
+			q.newWindow(g, 0, 0)
+		}
+		q.groups[""] = g
+	}
+}
+
+// truncate rounds ts down to a multiple of interval.
+func truncate(ts, interval int64) int64 {
+	r := ts % interval
+	if r < 0 {
+		r += interval
+	}
+	return ts - r
+}
+
+// condString returns the string representation of a possibly nil condition.
+func condString(expr Expr) string {
+	if expr == nil {
+		return ""
+	}
+	return expr.String()
+}
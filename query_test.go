@@ -0,0 +1,682 @@
+package jepl_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure a streaming query groups and aggregates the pushed documents.
+func TestQuery_PushFlush(t *testing.T) {
+	var docs []string
+	for i := 0; i < 10; i++ {
+		js := fmt.Sprintf(`{"uid": %d, "tcp": {"src_ip":%d, "dst_ip":%d, "in_bytes":%d, "out_bytes": 20, "in_pkts": %d, "out_pkts": 2}}`, i%3, i%2, i%3, i*10, i)
+		docs = append(docs, js)
+	}
+
+	type series struct {
+		key  string
+		tags map[string]interface{}
+		ps   []float64
+	}
+	for i, tt := range []struct {
+		s   string
+		exp []series
+	}{
+		{
+			s:   `select sum(tcp.in_bytes) from packetbeat where uid = 1`,
+			exp: []series{{key: ``, tags: map[string]interface{}{}, ps: []float64{120}}},
+		},
+		{
+			s: `select max(tcp.in_bytes), min(tcp.in_pkts), count(tcp.in_pkts), sum(tcp.in_pkts), avg(tcp.in_pkts) from packetbeat where uid = 1 group by tcp.src_ip, tcp.dst_ip`,
+			exp: []series{
+				{key: `tcp.dst_ip=1,tcp.src_ip=0`, tags: map[string]interface{}{"tcp.src_ip": float64(0), "tcp.dst_ip": float64(1)}, ps: []float64{40, 4, 1, 4, 4}},
+				{key: `tcp.dst_ip=1,tcp.src_ip=1`, tags: map[string]interface{}{"tcp.src_ip": float64(1), "tcp.dst_ip": float64(1)}, ps: []float64{70, 1, 2, 8, 4}},
+			},
+		},
+		{
+			s: `select sum(tcp.in_bytes + tcp.out_bytes) * 2 + count(tcp.in_pkts) from packetbeat where uid != 2 group by tcp.dst_ip`,
+			exp: []series{
+				{key: `tcp.dst_ip=0`, tags: map[string]interface{}{"tcp.dst_ip": float64(0)}, ps: []float64{524}},
+				{key: `tcp.dst_ip=1`, tags: map[string]interface{}{"tcp.dst_ip": float64(1)}, ps: []float64{363}},
+			},
+		},
+	} {
+		q, err := jepl.NewQuery(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		for _, doc := range docs {
+			if err := q.Push([]byte(doc)); err != nil {
+				t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+			}
+		}
+
+		res := q.Flush()
+		if len(res) != len(tt.exp) {
+			t.Errorf("%d. %q: series count mismatch: exp=%d got=%d", i, tt.s, len(tt.exp), len(res))
+			continue
+		}
+		for j, exp := range tt.exp {
+			if got := res[j].Key(); got != exp.key {
+				t.Errorf("%d. %q: series %d key mismatch: exp=%s got=%s", i, tt.s, j, exp.key, got)
+			}
+			if !reflect.DeepEqual(exp.tags, res[j].Tags) {
+				t.Errorf("%d. %q: series %d tags mismatch:\n  exp=%v\n  got=%v", i, tt.s, j, exp.tags, res[j].Tags)
+			}
+			if got := metrics(res[j].Points); !reflect.DeepEqual(exp.ps, got) {
+				t.Errorf("%d. %q: series %d points mismatch:\n  exp=%v\n  got=%v", i, tt.s, j, exp.ps, got)
+			}
+		}
+	}
+}
+
+// Ensure a parsed statement can be shared by concurrent queries.
+func TestNewSelectQuery_Concurrent(t *testing.T) {
+	stmt, err := jepl.ParseStatement(`select sum(x), max(x), avg(x) from foo group by host`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := stmt.String()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q := jepl.NewSelectQuery(stmt.(*jepl.SelectStatement))
+			for j := 0; j <= i; j++ {
+				q.Push([]byte(fmt.Sprintf(`{"host": "a", "x": %d}`, j)))
+			}
+			ps := q.Flush().Get(`host='a'`).Points
+			if sum := float64(i * (i + 1) / 2); ps[0].Metric != sum || ps[1].Metric != float64(i) || ps[2].Metric != sum/float64(i+1) {
+				errs <- fmt.Errorf("%d. unexpected points: %v", i, ps)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := stmt.String(); got != exp {
+		t.Fatalf("statement modified:\n  exp=%s\n  got=%s", exp, got)
+	}
+}
+
+// Ensure series carry their dimension values as tags.
+func TestQuery_Series(t *testing.T) {
+	q, err := jepl.NewQuery(`select sum(x) AS total, count(x) from foo group by host, tcp.dst_port, up`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{
+		`{"host": "a", "tcp": {"dst_port": 80}, "up": true, "x": 1}`,
+		`{"host": "a", "tcp": {"dst_port": 80}, "up": true, "x": 2}`,
+		`{"host": "b's", "tcp": {"dst_port": 443.5}, "up": false, "x": 4}`,
+		`{"tcp": {"dst_port": 80}, "up": true, "x": 8}`,
+	} {
+		if err := q.Push([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := q.Flush()
+	for i, tt := range []struct {
+		key  string
+		tags map[string]interface{}
+		ps   []float64
+	}{
+		{
+			key:  `host='a',tcp.dst_port=80,up=true`,
+			tags: map[string]interface{}{"host": "a", "tcp.dst_port": float64(80), "up": true},
+			ps:   []float64{3, 2},
+		},
+		{
+			key:  `host='b\'s',tcp.dst_port=443.5,up=false`,
+			tags: map[string]interface{}{"host": "b's", "tcp.dst_port": 443.5, "up": false},
+			ps:   []float64{4, 1},
+		},
+	} {
+		series := res.Get(tt.key)
+		if series == nil {
+			t.Fatalf("%d. missing series %s in %v", i, tt.key, res)
+		}
+		if series.Key() != tt.key {
+			t.Errorf("%d. key mismatch: exp=%s got=%s", i, tt.key, series.Key())
+		}
+		if !reflect.DeepEqual(tt.tags, series.Tags) {
+			t.Errorf("%d. tags mismatch:\n  exp=%v\n  got=%v", i, tt.tags, series.Tags)
+		}
+		if exp := []string{"total", "count"}; !reflect.DeepEqual(exp, series.Columns) {
+			t.Errorf("%d. columns mismatch:\n  exp=%v\n  got=%v", i, exp, series.Columns)
+		}
+		if got := metrics(series.Points); !reflect.DeepEqual(tt.ps, got) {
+			t.Errorf("%d. points mismatch:\n  exp=%v\n  got=%v", i, tt.ps, got)
+		}
+	}
+	if len(res) != 2 {
+		t.Fatalf("series count mismatch: exp=2 got=%d", len(res))
+	}
+}
+
+// metrics returns the metric values of ps.
+func metrics(ps jepl.Points) []float64 {
+	var a []float64
+	for _, p := range ps {
+		a = append(a, p.Metric)
+	}
+	return a
+}
+
+// Ensure documents failing evaluation are reported and skipped.
+func TestQuery_PushError(t *testing.T) {
+	if _, err := jepl.NewQuery(`select sum(x) frm foo`); err == nil {
+		t.Fatal("expected parse error")
+	} else if _, ok := err.(*jepl.ParseError); !ok {
+		t.Fatalf("unexpected error type %T", err)
+	}
+
+	q, err := jepl.NewQuery(`select sum(x) from foo where uid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range []struct {
+		doc string
+		err string
+	}{
+		{doc: `{"uid": true, "x": 1}`},
+		{doc: `{"uid": 1, "x": 2}`, err: `condition uid evaluated to 1, expected bool at doc 1`},
+		{doc: `{"uid": true, "x": 3}`},
+		{doc: `{"x": 4}`},
+	} {
+		if err := q.Push([]byte(tt.doc)); errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s", i, tt.doc, tt.err, err)
+		}
+	}
+	if got := q.Flush().Get("").Points[0].Metric; got != 4 {
+		t.Fatalf("exp=4 got=%v", got)
+	}
+}
+
+// Ensure Flush resets the aggregate state.
+func TestQuery_FlushResets(t *testing.T) {
+	q, err := jepl.NewQuery(`select sum(x) from foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Push([]byte(`{"x": 1}`))
+	q.Push([]byte(`{"x": 2}`))
+	if got := q.Flush().Get("").Points[0].Metric; got != 3 {
+		t.Fatalf("exp=3 got=%v", got)
+	}
+
+	q.Push([]byte(`{"x": 5}`))
+	if got := q.Flush().Get("").Points[0].Metric; got != 5 {
+		t.Fatalf("exp=5 got=%v", got)
+	}
+}
+
+// Ensure events are bucketed by their own timestamps.
+func TestQuery_GroupByTime(t *testing.T) {
+	docs := []string{
+		`{"@timestamp": "2016-12-14T23:59:55+08:00", "host": "a", "x": 1}`,
+		`{"@timestamp": "2016-12-14T23:59:10+08:00", "host": "a", "x": 2}`,
+		`{"@timestamp": "2016-12-15T00:00:05+08:00", "host": "a", "x": 4}`,
+		`{"@timestamp": 1481731270, "host": "b", "x": 8}`,
+		`{"@timestamp": 1481731199.5, "host": "b", "x": 16}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp map[string][]float64 // metric and bucket start pairs
+	}{
+		{
+			s: `select sum(x) from foo group by time(1m)`,
+			exp: map[string][]float64{
+				"": {19, 1481731140, 4, 1481731200, 8, 1481731260},
+			},
+		},
+		{
+			s: `select sum(x) from foo group by host, time(1m)`,
+			exp: map[string][]float64{
+				"host='a'": {3, 1481731140, 4, 1481731200},
+				"host='b'": {16, 1481731140, 8, 1481731260},
+			},
+		},
+	} {
+		q, err := jepl.NewQuery(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		for _, doc := range docs {
+			if err := q.Push([]byte(doc)); err != nil {
+				t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+			}
+		}
+
+		res := q.Flush()
+		if len(res) != len(tt.exp) {
+			t.Fatalf("%d. %q: group count mismatch: exp=%d got=%d", i, tt.s, len(tt.exp), len(res))
+		}
+		for k, exp := range tt.exp {
+			var got []float64
+			for _, p := range res.Get(k).Points {
+				got = append(got, p.Metric, float64(p.TS))
+			}
+			if !reflect.DeepEqual(exp, got) {
+				t.Errorf("%d. %q: points mismatch for %q:\n  exp=%v\n  got=%v", i, tt.s, k, exp, got)
+			}
+		}
+	}
+}
+
+// Ensure HAVING drops the groups and windows whose aggregates fail it.
+func TestQuery_Having(t *testing.T) {
+	docs := []string{
+		`{"@timestamp": 1481731140, "host": "a", "x": 1}`,
+		`{"@timestamp": 1481731150, "host": "a", "x": 2}`,
+		`{"@timestamp": 1481731210, "host": "a", "x": 4}`,
+		`{"@timestamp": 1481731150, "host": "b", "x": 8}`,
+		`{"@timestamp": 1481731160, "host": "c", "x": 16}`,
+		`{"@timestamp": 1481731170, "host": "c"}`,
+	}
+
+	for i, tt := range []struct {
+		s   string
+		exp map[string][]float64
+	}{
+		{
+			s: `select sum(x) from foo group by host having sum(x) > 3`,
+			exp: map[string][]float64{
+				"host='a'": {7},
+				"host='b'": {8},
+				"host='c'": {16},
+			},
+		},
+		{
+			s: `select sum(x) AS total from foo group by host having total >= 8 AND count(*) = 1`,
+			exp: map[string][]float64{
+				"host='b'": {8},
+			},
+		},
+		{
+			s: `select sum(x) AS total, count(x) AS n from foo group by host having total / n > 4`,
+			exp: map[string][]float64{
+				"host='b'": {8, 1},
+				"host='c'": {16, 1},
+			},
+		},
+		{
+			s: `select sum(x) from foo group by host, time(1m) having count(x) = 1`,
+			exp: map[string][]float64{
+				"host='a'": {4},
+				"host='b'": {8},
+				"host='c'": {16},
+			},
+		},
+		{
+			s:   `select sum(x) from foo having sum(x) < 0`,
+			exp: map[string][]float64{},
+		},
+	} {
+		q, err := jepl.NewQuery(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		for _, doc := range docs {
+			if err := q.Push([]byte(doc)); err != nil {
+				t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+			}
+		}
+
+		res := q.Flush()
+		if len(res) != len(tt.exp) {
+			t.Fatalf("%d. %q: group count mismatch: exp=%d got=%d", i, tt.s, len(tt.exp), len(res))
+		}
+		for k, exp := range tt.exp {
+			series := res.Get(k)
+			if series == nil {
+				t.Fatalf("%d. %q: missing series %s", i, tt.s, k)
+			}
+			if got := metrics(series.Points); !reflect.DeepEqual(exp, got) {
+				t.Errorf("%d. %q: points mismatch for %q:\n  exp=%v\n  got=%v", i, tt.s, k, exp, got)
+			}
+		}
+	}
+}
+
+// Ensure ORDER BY, LIMIT and OFFSET rank the groups of each window.
+func TestQuery_OrderBy(t *testing.T) {
+	docs := []string{
+		`{"@timestamp": 1481731140, "host": "a", "x": 1}`,
+		`{"@timestamp": 1481731150, "host": "b", "x": 8}`,
+		`{"@timestamp": 1481731160, "host": "c", "x": 4}`,
+		`{"@timestamp": 1481731170, "host": "d", "y": 1}`,
+		`{"@timestamp": 1481731210, "host": "a", "x": 16}`,
+		`{"@timestamp": 1481731220, "host": "c", "x": 2}`,
+	}
+
+	for i, tt := range []struct {
+		s    string
+		keys []string
+		exp  [][]float64 // metrics of each series, in order
+	}{
+		{
+			s:    `select sum(x) from foo group by host`,
+			keys: []string{"host='a'", "host='b'", "host='c'", "host='d'"},
+			exp:  [][]float64{{17}, {8}, {6}, {0}},
+		},
+		{
+			s:    `select sum(x) AS total from foo group by host order by total desc`,
+			keys: []string{"host='a'", "host='b'", "host='c'", "host='d'"},
+			exp:  [][]float64{{17}, {8}, {6}, {0}},
+		},
+		{
+			s:    `select max(x) from foo group by host order by max(x)`,
+			keys: []string{"host='d'", "host='c'", "host='b'", "host='a'"},
+			exp:  [][]float64{{0}, {4}, {8}, {16}},
+		},
+		{
+			s:    `select first(x) from foo group by host order by first(x) desc`,
+			keys: []string{"host='b'", "host='c'", "host='a'", "host='d'"},
+			exp:  [][]float64{{8}, {4}, {1}, {0}},
+		},
+		{
+			s:    `select first(x) from foo group by host order by first(x)`,
+			keys: []string{"host='a'", "host='c'", "host='b'", "host='d'"},
+			exp:  [][]float64{{1}, {4}, {8}, {0}},
+		},
+		{
+			s:    `select sum(x) from foo group by host order by count(*) desc, sum(x) asc limit 2`,
+			keys: []string{"host='c'", "host='a'"},
+			exp:  [][]float64{{6}, {17}},
+		},
+		{
+			s:    `select sum(x) from foo group by host order by sum(x) desc limit 2 offset 1`,
+			keys: []string{"host='b'", "host='c'"},
+			exp:  [][]float64{{8}, {6}},
+		},
+		{
+			s:    `select sum(x) from foo group by host limit 10 offset 4`,
+			keys: nil,
+		},
+		{
+			s:    `select sum(x) from foo group by host, time(1m) order by sum(x) desc limit 1`,
+			keys: []string{"host='b'", "host='a'"},
+			exp:  [][]float64{{8}, {16}},
+		},
+	} {
+		q, err := jepl.NewQuery(tt.s)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		for _, doc := range docs {
+			if err := q.Push([]byte(doc)); err != nil {
+				t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+			}
+		}
+
+		var keys []string
+		var got [][]float64
+		for _, series := range q.Flush() {
+			keys = append(keys, series.Key())
+			got = append(got, metrics(series.Points))
+		}
+		if !reflect.DeepEqual(tt.keys, keys) {
+			t.Errorf("%d. %q: series mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.keys, keys)
+		} else if !reflect.DeepEqual(tt.exp, got) {
+			t.Errorf("%d. %q: points mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.exp, got)
+		}
+	}
+}
+
+// Ensure the timestamp field is configurable and required by time dimensions.
+func TestQuery_TimeField(t *testing.T) {
+	q, err := jepl.NewQuery(`select count(x) from foo group by time(1h)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.TimeField = "event.ts"
+
+	if err := q.Push([]byte(`{"event": {"ts": 7200}, "x": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push([]byte(`{"@timestamp": 7200, "x": 1}`)); errstring(err) != `missing time field at doc 1` || !errors.Is(err, jepl.ErrMissingTime) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.Push([]byte(`{"event": {"ts": "yesterday"}, "x": 1}`)); errstring(err) != `invalid timestamp string at doc 2` || !errors.Is(err, jepl.ErrInvalidTime) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ps := q.Flush().Get("").Points
+	if len(ps) != 1 || ps[0].Metric != 1 || ps[0].TS != 7200 {
+		t.Fatalf("unexpected points: %v", ps)
+	}
+}
+
+// Ensure now() is taken from the query's Valuer and event times may be
+// epoch seconds, epoch milliseconds or RFC3339.
+func TestQuery_Now(t *testing.T) {
+	q, err := jepl.NewQuery(`select count(*) from foo where @timestamp > now() - 15m AND @timestamp <= now() group by time(1h)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Valuer = &jepl.NowValuer{Now: time.Date(2016, 12, 14, 16, 0, 0, 0, time.UTC)}
+
+	for _, doc := range []string{
+		`{"@timestamp": 1481730600}`,
+		`{"@timestamp": 1481730600000}`,
+		`{"@timestamp": 1481731199999.5}`,
+		`{"@timestamp": "2016-12-14T23:50:00+08:00"}`,
+		`{"@timestamp": "2016-12-14T15:40:00Z"}`,
+		`{"@timestamp": 1481731260}`,
+		`{"@timestamp": "now"}`,
+	} {
+		if err := q.Push([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ps := q.Flush().Get("").Points
+	if len(ps) != 1 || ps[0].Metric != 4 || ps[0].TS != 1481727600 {
+		t.Fatalf("unexpected points: %v", ps)
+	}
+}
+
+// Ensure events are counted in every hopping window they fall in.
+func TestQuery_HoppingWindow(t *testing.T) {
+	q, err := jepl.NewQuery(`select sum(x) from foo group by time(3m, 1m)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{
+		`{"@timestamp": 30, "x": 1}`,
+		`{"@timestamp": 90, "x": 2}`,
+		`{"@timestamp": 200, "x": 4}`,
+	} {
+		if err := q.Push([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []float64
+	for _, p := range q.Flush().Get("").Points {
+		got = append(got, p.Metric, float64(p.TS))
+	}
+	exp := []float64{1, -120, 3, -60, 3, 0, 6, 60, 4, 120, 4, 180}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("points mismatch:\n  exp=%v\n  got=%v", exp, got)
+	}
+}
+
+// Ensure session windows close after a gap and merge when bridged.
+func TestQuery_SessionWindow(t *testing.T) {
+	q, err := jepl.NewQuery(`select sum(x), count(x), max(x) from foo group by host, session(1m)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{
+		`{"@timestamp": 0, "host": "a", "x": 1}`,
+		`{"@timestamp": 50, "host": "a", "x": 2}`,
+		`{"@timestamp": 200, "host": "a", "x": 4}`,
+		`{"@timestamp": 300, "host": "a", "x": 8}`,
+		`{"@timestamp": 250, "host": "a", "x": 16}`, // bridges the sessions at 200 and 300
+		`{"@timestamp": 20, "host": "b", "x": 32}`,
+	} {
+		if err := q.Push([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := q.Flush()
+	for k, exp := range map[string][]float64{
+		"host='a'": {3, 2, 2, 0, 28, 3, 16, 200},
+		"host='b'": {32, 1, 32, 20},
+	} {
+		var got []float64
+		for i, p := range res.Get(k).Points {
+			got = append(got, p.Metric)
+			if i%3 == 2 {
+				got = append(got, float64(p.TS))
+			}
+		}
+		if !reflect.DeepEqual(exp, got) {
+			t.Errorf("points mismatch for %q:\n  exp=%v\n  got=%v", k, exp, got)
+		}
+	}
+}
+
+// Ensure merged sessions resolve selector ties in favour of earlier events.
+func TestQuery_SessionMergeOrder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		q, err := jepl.NewQuery(`select top(x, 1, tag) from foo group by session(1m)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range []string{
+			`{"@timestamp": 0, "tag": "a", "x": 5}`,
+			`{"@timestamp": 100, "tag": "b", "x": 5}`,
+			`{"@timestamp": 50, "tag": "c", "x": 1}`, // bridges the sessions
+		} {
+			if err := q.Push([]byte(doc)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rows := q.Flush().Get("").Rows
+		if len(rows) != 1 || !reflect.DeepEqual(rows[0].Values, []interface{}{float64(5), "a"}) {
+			t.Fatalf("%d. unexpected rows: %v", i, rows)
+		}
+	}
+}
+
+// Ensure the watermark closes windows and rejects late events.
+func TestQuery_Watermark(t *testing.T) {
+	q, err := jepl.NewQuery(`select count(x) from foo group by time(1m)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AllowedLateness = 30 * time.Second
+
+	for i, tt := range []struct {
+		ts  int
+		err error
+	}{
+		{ts: 10},
+		{ts: 70},
+		{ts: 50}, // watermark at 40s, window [0, 60) still open
+		{ts: 100},
+		{ts: 55, err: jepl.ErrLateEvent}, // watermark at 70s
+		{ts: 65},
+	} {
+		if err := q.Push([]byte(fmt.Sprintf(`{"@timestamp": %d, "x": 1}`, tt.ts))); err != tt.err {
+			t.Fatalf("%d. unexpected error: exp=%v got=%v", i, tt.err, err)
+		}
+	}
+
+	ps := q.Emit().Get("").Points
+	if len(ps) != 1 || ps[0].Metric != 2 || ps[0].TS != 0 {
+		t.Fatalf("unexpected emitted points: %v", ps)
+	}
+	if res := q.Emit(); len(res) != 0 {
+		t.Fatalf("unexpected second emit: %v", res)
+	}
+
+	ps = q.Flush().Get("").Points
+	if len(ps) != 1 || ps[0].Metric != 3 || ps[0].TS != 60 {
+		t.Fatalf("unexpected flushed points: %v", ps)
+	}
+}
+
+// Ensure emitting closed windows releases the state of their groups.
+func TestQuery_EmitReleasesGroups(t *testing.T) {
+	q, err := jepl.NewQuery(`select count(x) from foo group by host, time(1m)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AllowedLateness = 0
+
+	for i := 0; i < 100; i++ {
+		if err := q.Push([]byte(fmt.Sprintf(`{"@timestamp": 10, "host": "h%d", "x": 1}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Push([]byte(`{"@timestamp": 70, "host": "h0", "x": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.NumGroups(); n != 100 {
+		t.Fatalf("unexpected groups before emit: %d", n)
+	}
+
+	if res := q.Emit(); len(res) != 100 {
+		t.Fatalf("unexpected emitted series: %d", len(res))
+	}
+	if n := q.NumGroups(); n != 1 {
+		t.Fatalf("unexpected groups after emit: exp=1 got=%d", n)
+	}
+	if ps := q.Flush().Get(`host='h0'`).Points; len(ps) != 1 || ps[0].TS != 60 {
+		t.Fatalf("unexpected flushed points: %v", ps)
+	}
+
+	// Without dimensions the single group outlives its windows.
+	q, err = jepl.NewQuery(`select count(x) from foo group by time(1m)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AllowedLateness = 0
+	q.Push([]byte(`{"@timestamp": 10, "x": 1}`))
+	q.Push([]byte(`{"@timestamp": 70, "x": 1}`))
+	q.Emit()
+	q.Push([]byte(`{"@timestamp": 130, "x": 1}`))
+	if res := q.Emit(); len(res) != 1 || q.NumGroups() != 1 {
+		t.Fatalf("unexpected emit: %v, groups=%d", res, q.NumGroups())
+	}
+}
+
+func BenchmarkQuery_GroupByHighCardinality(b *testing.B) {
+	b.ReportAllocs()
+
+	q, err := jepl.NewQuery(`select sum(in_bytes), count(in_bytes) from packetbeat group by src_ip, dst_port`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	docs := make([][]byte, 10000)
+	for i := range docs {
+		docs[i] = []byte(fmt.Sprintf(`{"src_ip": "10.0.%d.%d", "dst_port": %d, "in_bytes": %d}`, i/256%256, i%256, i%7, i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, doc := range docs {
+			q.Push(doc)
+		}
+		q.Flush()
+	}
+}
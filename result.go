@@ -0,0 +1,102 @@
+package jepl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Result holds the series of a query in order. See Query.Flush.
+type Result []*Series
+
+// Get returns the series identified by key, or nil if there is none.
+func (r Result) Get(key string) *Series {
+	for _, s := range r {
+		if s.Key() == key {
+			return s
+		}
+	}
+	return nil
+}
+
+// Series represents the metric points of a single group.
+type Series struct {
+	// Tags maps each GROUP BY field to the group's value.
+	Tags map[string]interface{}
+
+	// Columns names the fields of the select statement.
+	Columns []string
+
+	// Rows holds the values of every time window, in time order. A window
+	// has one row, or a row per value selected by top() or bottom().
+	Rows []Row
+
+	// Points holds one point per column for every row, in row order.
+	// Non-numeric values, such as the companion fields of a selector, are zero.
+	Points Points
+}
+
+// Row represents the values of a single row of a series.
+type Row struct {
+	// TS is the window start in unix seconds, or the evaluation time
+	// without a time dimension.
+	TS int64
+
+	// Values holds a value per column. Missing values are nil.
+	Values []interface{}
+}
+
+// rowPoints flattens rows into one point per value.
+func rowPoints(rows []Row) Points {
+	var ps Points
+	for _, row := range rows {
+		for _, v := range row.Values {
+			f, _ := v.(float64)
+			ps = append(ps, point{f, row.TS})
+		}
+	}
+	return ps
+}
+
+// Key returns a canonical key identifying the series by its tags.
+// Tags are sorted by name, e.g. host='a',tcp.dst_port=80.
+func (s *Series) Key() string {
+	return seriesKey(s.Tags)
+}
+
+// seriesKey encodes tags in the canonical form used by Series.Key.
+func seriesKey(tags map[string]interface{}) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for i, name := range names {
+		if i > 0 {
+			_ = buf.WriteByte(',')
+		}
+		_, _ = buf.WriteString(name)
+		_ = buf.WriteByte('=')
+		_, _ = buf.WriteString(formatTag(tags[name]))
+	}
+	return buf.String()
+}
+
+// formatTag returns the literal representation of a tag value.
+func formatTag(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return QuoteString(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "nil"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,25 @@
+package jepl_test
+
+import (
+	"testing"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure series keys are canonical regardless of tag order.
+func TestSeries_Key(t *testing.T) {
+	for i, tt := range []struct {
+		tags map[string]interface{}
+		key  string
+	}{
+		{tags: nil, key: ``},
+		{tags: map[string]interface{}{"host": "a"}, key: `host='a'`},
+		{tags: map[string]interface{}{"up": true, "host": "a", "port": float64(80)}, key: `host='a',port=80,up=true`},
+		{tags: map[string]interface{}{"ratio": 0.25, "name": "it's"}, key: `name='it\'s',ratio=0.25`},
+	} {
+		s := &jepl.Series{Tags: tt.tags}
+		if got := s.Key(); got != tt.key {
+			t.Errorf("%d. key mismatch:\n  exp=%s\n  got=%s", i, tt.key, got)
+		}
+	}
+}
@@ -45,6 +45,12 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return s.scanString()
 	case '\'':
 		return s.scanString()
+	case '`':
+		// A backtick quotes an identifier, which is never a keyword.
+		if tok, pos, lit = s.scanString(); tok == STRING {
+			tok = IDENT
+		}
+		return tok, pos, lit
 	case '.':
 		ch1, _ := s.r.read()
 		s.r.unread()
@@ -52,8 +58,10 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 			return s.scanNumber()
 		}
 		return DOT, pos, ""
-	case '+', '-':
-		return s.scanNumber()
+	case '+':
+		return ADD, pos, ""
+	case '-':
+		return SUB, pos, ""
 	case '*':
 		return MUL, pos, ""
 	case '/':
@@ -97,6 +105,12 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return RBRACKET, pos, ""
 	case ',':
 		return COMMA, pos, ""
+	case '$':
+		if ch1, _ := s.r.read(); isIdentChar(ch1) {
+			s.r.unread()
+			return BOUNDPARAM, pos, ScanBareIdent(s.r)
+		}
+		s.r.unread()
 	}
 
 	return ILLEGAL, pos, string(ch0)
@@ -198,30 +212,13 @@ func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
 }
 
 // scanNumber consumes anything that looks like the start of a number.
-// Numbers start with a digit, full stop, plus sign or minus sign.
-// This function can return non-number tokens if a scan is a false positive.
-// For example, a minus sign followed by a letter will just return a minus sign.
+// Numbers start with a digit or a full stop. Signs are scanned as operators
+// and applied by the parser.
 func (s *Scanner) scanNumber() (tok Token, pos Pos, lit string) {
 	var buf bytes.Buffer
 
-	// Check if the initial rune is a "+" or "-".
 	ch, pos := s.r.curr()
-	if ch == '+' || ch == '-' {
-		// Peek at the next two runes.
-		ch1, _ := s.r.read()
-		ch2, _ := s.r.read()
-		s.r.unread()
-		s.r.unread()
-
-		// This rune must be followed by a digit or a full stop and a digit.
-		if isDigit(ch1) || (ch1 == '.' && isDigit(ch2)) {
-			_, _ = buf.WriteRune(ch)
-		} else if ch == '+' {
-			return ADD, pos, ""
-		} else if ch == '-' {
-			return SUB, pos, ""
-		}
-	} else if ch == '.' {
+	if ch == '.' {
 		// Peek and see if the next rune is a digit.
 		ch1, _ := s.r.read()
 		s.r.unread()
@@ -238,6 +235,27 @@ func (s *Scanner) scanNumber() (tok Token, pos Pos, lit string) {
 	// Read as many digits as possible.
 	_, _ = buf.WriteString(s.scanDigits())
 
+	// A leading "0x" followed by hex digits is a hexadecimal integer.
+	if buf.String() == "0" {
+		if ch0, _ := s.r.read(); ch0 == 'x' || ch0 == 'X' {
+			if ch1, _ := s.r.read(); isHexDigit(ch1) {
+				_, _ = buf.WriteRune(ch0)
+				_, _ = buf.WriteRune(ch1)
+				for {
+					if ch2, _ := s.r.read(); isHexDigit(ch2) {
+						_, _ = buf.WriteRune(ch2)
+					} else {
+						s.r.unread()
+						break
+					}
+				}
+				return INTEGER, pos, buf.String()
+			}
+			s.r.unread()
+		}
+		s.r.unread()
+	}
+
 	// If next code points are a full stop and digit then consume them.
 	isDecimal := false
 	if ch0, _ := s.r.read(); ch0 == '.' {
@@ -253,13 +271,67 @@ func (s *Scanner) scanNumber() (tok Token, pos Pos, lit string) {
 		s.r.unread()
 	}
 
+	// If next code points are an exponent then consume them, e.g. e9 or E-3.
+	if exp := s.scanExponent(); exp != "" {
+		_, _ = buf.WriteString(exp)
+		isDecimal = true
+	}
+
 	// Read as a duration or integer if it doesn't have a fractional part.
 	if !isDecimal {
+		// If the next rune is a letter then this is a duration token.
+		if ch0, _ := s.r.read(); isLetter(ch0) || ch0 == 'µ' {
+			_, _ = buf.WriteRune(ch0)
+			for {
+				ch1, _ := s.r.read()
+				if !isLetter(ch1) && ch1 != 'µ' {
+					s.r.unread()
+					break
+				}
+				_, _ = buf.WriteRune(ch1)
+			}
+
+			// Continue reading digits and letters as part of this token.
+			for {
+				if ch0, _ := s.r.read(); isLetter(ch0) || ch0 == 'µ' || isDigit(ch0) {
+					_, _ = buf.WriteRune(ch0)
+				} else {
+					s.r.unread()
+					break
+				}
+			}
+			return DURATIONVAL, pos, buf.String()
+		}
+		s.r.unread()
 		return INTEGER, pos, buf.String()
 	}
 	return NUMBER, pos, buf.String()
 }
 
+// scanExponent consumes the exponent of a number, an "e" or "E" followed
+// by an optionally signed integer. Nothing is consumed if the next code
+// points are not an exponent.
+func (s *Scanner) scanExponent() string {
+	ch0, _ := s.r.read()
+	if ch0 != 'e' && ch0 != 'E' {
+		s.r.unread()
+		return ""
+	}
+
+	ch1, _ := s.r.read()
+	if ch1 == '+' || ch1 == '-' {
+		if ch2, _ := s.r.read(); isDigit(ch2) {
+			return string(ch0) + string(ch1) + string(ch2) + s.scanDigits()
+		}
+		s.r.unread()
+	} else if isDigit(ch1) {
+		return string(ch0) + string(ch1) + s.scanDigits()
+	}
+	s.r.unread()
+	s.r.unread()
+	return ""
+}
+
 // scanDigits consume a contiguous series of digits.
 func (s *Scanner) scanDigits() string {
 	var buf bytes.Buffer
@@ -283,11 +355,16 @@ func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && c
 // isDigit returns true if the rune is a digit.
 func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
 
+// isHexDigit returns true if the rune is a hexadecimal digit.
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
 // isIdentChar returns true if the rune can be used in an unquoted identifier.
 func isIdentChar(ch rune) bool { return isLetter(ch) || isDigit(ch) || ch == '_' || ch == '@' }
 
 // isIdentFirstChar returns true if the rune can be used as the first char in an unquoted identifer.
-func isIdentFirstChar(ch rune) bool { return isLetter(ch) || ch == '_' }
+func isIdentFirstChar(ch rune) bool { return isLetter(ch) || ch == '_' || ch == '@' }
 
 // bufScanner represents a wrapper for scanner to add a buffer.
 // It provides a fixed-length circular buffer that can be unread.
@@ -509,6 +586,8 @@ func ScanString(r io.RuneScanner) (string, error) {
 				_, _ = buf.WriteRune('"')
 			} else if ch1 == '\'' {
 				_, _ = buf.WriteRune('\'')
+			} else if ch1 == ending {
+				_, _ = buf.WriteRune(ending)
 			} else {
 				return string(ch0) + string(ch1), errBadEscape
 			}
@@ -51,6 +51,14 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `or`, tok: jepl.OR},
 		{s: `NI`, tok: jepl.NI},
 		{s: `IN`, tok: jepl.IN},
+		{s: `IS`, tok: jepl.IS},
+		{s: `is`, tok: jepl.IS},
+		{s: `BETWEEN`, tok: jepl.BETWEEN},
+		{s: `LIKE`, tok: jepl.LIKE},
+		{s: `ilike`, tok: jepl.ILIKE},
+		{s: `STARTS`, tok: jepl.STARTSWITH},
+		{s: `ends`, tok: jepl.ENDSWITH},
+		{s: `CONTAINS`, tok: jepl.CONTAINS},
 
 		{s: `=`, tok: jepl.EQ},
 		{s: `!=`, tok: jepl.NEQ},
@@ -78,6 +86,12 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `true`, tok: jepl.TRUE},
 		{s: `false`, tok: jepl.FALSE},
 
+		// Bound parameters
+		{s: `$host`, tok: jepl.BOUNDPARAM, lit: `host`},
+		{s: `$1 `, tok: jepl.BOUNDPARAM, lit: `1`},
+		{s: `$`, tok: jepl.ILLEGAL, lit: `$`},
+		{s: `$ x`, tok: jepl.ILLEGAL, lit: `$`},
+
 		// Strings
 		{s: `"foo"`, tok: jepl.STRING, lit: `foo`},
 		{s: `"foo\\bar"`, tok: jepl.STRING, lit: `foo\bar`},
@@ -86,6 +100,13 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `test"`, tok: jepl.BADSTRING, lit: "", pos: jepl.Pos{Line: 0, Char: 3}},
 		{s: `"test`, tok: jepl.BADSTRING, lit: `test`},
 
+		// Quoted identifiers
+		{s: "`user-agent`", tok: jepl.IDENT, lit: `user-agent`},
+		{s: "`select`", tok: jepl.IDENT, lit: `select`},
+		{s: "`a\\`b`", tok: jepl.IDENT, lit: "a`b"},
+		{s: "`a\\xb`", tok: jepl.BADESCAPE, lit: `\x`, pos: jepl.Pos{Line: 0, Char: 3}},
+		{s: "`test", tok: jepl.BADSTRING, lit: `test`},
+
 		{s: `'testing 123!'`, tok: jepl.STRING, lit: `testing 123!`},
 		{s: `'foo\nbar'`, tok: jepl.STRING, lit: "foo\nbar"},
 		{s: `'foo\\bar'`, tok: jepl.STRING, lit: "foo\\bar"},
@@ -95,21 +116,49 @@ func TestScanner_Scan(t *testing.T) {
 
 		// Numbers
 		{s: `100`, tok: jepl.INTEGER, lit: `100`},
-		{s: `-100`, tok: jepl.INTEGER, lit: `-100`},
+		{s: `-100`, tok: jepl.SUB},
 		{s: `100.23`, tok: jepl.NUMBER, lit: `100.23`},
-		{s: `+100.23`, tok: jepl.NUMBER, lit: `+100.23`},
-		{s: `-100.23`, tok: jepl.NUMBER, lit: `-100.23`},
-		{s: `-100.`, tok: jepl.NUMBER, lit: `-100`},
+		{s: `+100.23`, tok: jepl.ADD},
+		{s: `-100.23`, tok: jepl.SUB},
+		{s: `100.`, tok: jepl.NUMBER, lit: `100`},
 		{s: `.23`, tok: jepl.NUMBER, lit: `.23`},
-		{s: `+.23`, tok: jepl.NUMBER, lit: `+.23`},
-		{s: `-.23`, tok: jepl.NUMBER, lit: `-.23`},
+		{s: `+.23`, tok: jepl.ADD},
+		{s: `-.23`, tok: jepl.SUB},
 		//{s: `.`, tok: jepl.ILLEGAL, lit: `.`},
 		{s: `-.`, tok: jepl.SUB, lit: ``},
 		{s: `+.`, tok: jepl.ADD, lit: ``},
 		{s: `10.3s`, tok: jepl.NUMBER, lit: `10.3`},
+		{s: `1e9`, tok: jepl.NUMBER, lit: `1e9`},
+		{s: `1.5E-3`, tok: jepl.NUMBER, lit: `1.5E-3`},
+		{s: `.5e+10`, tok: jepl.NUMBER, lit: `.5e+10`},
+		{s: `1e+`, tok: jepl.DURATIONVAL, lit: `1e`},
+		{s: `2.5e`, tok: jepl.NUMBER, lit: `2.5`},
+		{s: `0x1F`, tok: jepl.INTEGER, lit: `0x1F`},
+		{s: `0Xff`, tok: jepl.INTEGER, lit: `0Xff`},
+		{s: `0xg`, tok: jepl.DURATIONVAL, lit: `0xg`},
+		{s: `0`, tok: jepl.INTEGER, lit: `0`},
+
+		// Durations
+		{s: `10u`, tok: jepl.DURATIONVAL, lit: `10u`},
+		{s: `10µ`, tok: jepl.DURATIONVAL, lit: `10µ`},
+		{s: `10ms`, tok: jepl.DURATIONVAL, lit: `10ms`},
+		{s: `1s`, tok: jepl.DURATIONVAL, lit: `1s`},
+		{s: `10m`, tok: jepl.DURATIONVAL, lit: `10m`},
+		{s: `10h`, tok: jepl.DURATIONVAL, lit: `10h`},
+		{s: `10d`, tok: jepl.DURATIONVAL, lit: `10d`},
+		{s: `10w`, tok: jepl.DURATIONVAL, lit: `10w`},
+		{s: `1h30m`, tok: jepl.DURATIONVAL, lit: `1h30m`},
+		{s: `250ms`, tok: jepl.DURATIONVAL, lit: `250ms`},
+		{s: `10x`, tok: jepl.DURATIONVAL, lit: `10x`}, // non-duration unit, but scanned as a duration value
 
 		// Keywords
 		{s: `ALL`, tok: jepl.ALL},
+		{s: `ANY`, tok: jepl.ANY},
+		{s: `DISTINCT`, tok: jepl.DISTINCT},
+		{s: `NOT`, tok: jepl.NOT},
+		{s: `not`, tok: jepl.NOT},
+		{s: `NULL`, tok: jepl.NULL},
+		{s: `WITH`, tok: jepl.WITH},
 		{s: `FROM`, tok: jepl.FROM},
 		{s: `SELECT`, tok: jepl.SELECT},
 		{s: `WHERE`, tok: jepl.WHERE},
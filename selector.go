@@ -0,0 +1,101 @@
+package jepl
+
+import (
+	"fmt"
+	"sort"
+)
+
+func init() {
+	for _, name := range []string{"top", "bottom"} {
+		top := name == "top"
+		RegisterAggregate(name, &Aggregate{
+			Args:     []ArgType{ExprArg, NumberArg, FieldArg},
+			Optional: 1,
+			Variadic: true,
+			Validate: validateSelector,
+			New: func(args []Expr) Accumulator {
+				return &selectorAccumulator{top: top, n: int(literalNumber(args[1]))}
+			},
+		})
+	}
+}
+
+// isSelector returns true if c selects multiple rows, i.e. top() or bottom().
+func isSelector(c *Call) bool {
+	return c.Name == "top" || c.Name == "bottom"
+}
+
+// validateSelector checks the number of rows selected by a call.
+func validateSelector(c *Call) error {
+	if lit, ok := c.Args[1].(*IntegerLiteral); !ok || lit.Val < 1 {
+		return fmt.Errorf("%s() expects a positive integer number of rows, got %s", c.Name, c.Args[1])
+	}
+	return nil
+}
+
+// selection is a row kept by a selector: the selected value followed by
+// the values of the companion fields.
+type selection struct {
+	value  float64
+	values []interface{}
+	seq    int // order added, breaking ties in favour of earlier events
+}
+
+// selectorAccumulator keeps the n rows with the largest, or smallest, values.
+type selectorAccumulator struct {
+	top  bool
+	n    int
+	rows []selection
+	seq  int
+}
+
+func (a *selectorAccumulator) Add(ts int64, args []interface{}) {
+	v, ok := number(args[0])
+	if !ok {
+		return
+	}
+	values := make([]interface{}, 0, len(args)-1)
+	values = append(values, v)
+	values = append(values, args[2:]...)
+
+	a.add(selection{value: v, values: values, seq: a.seq})
+	a.seq++
+}
+
+// add inserts row in order, dropping the last row beyond n.
+func (a *selectorAccumulator) add(row selection) {
+	i := sort.Search(len(a.rows), func(i int) bool { return a.less(row, a.rows[i]) })
+	if i == a.n {
+		return
+	}
+	if len(a.rows) < a.n {
+		a.rows = append(a.rows, selection{})
+	}
+	copy(a.rows[i+1:], a.rows[i:])
+	a.rows[i] = row
+}
+
+// less returns true if x ranks before y.
+func (a *selectorAccumulator) less(x, y selection) bool {
+	if x.value != y.value {
+		return (x.value > y.value) == a.top
+	}
+	return x.seq < y.seq
+}
+
+func (a *selectorAccumulator) Merge(other Accumulator) {
+	for _, row := range other.(*selectorAccumulator).rows {
+		row.seq += a.seq
+		a.add(row)
+	}
+	a.seq += other.(*selectorAccumulator).seq
+}
+
+// Result returns the selected rows in rank order.
+func (a *selectorAccumulator) Result() interface{} {
+	rows := make([][]interface{}, len(a.rows))
+	for i, row := range a.rows {
+		rows[i] = row.values
+	}
+	return rows
+}
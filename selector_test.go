@@ -0,0 +1,95 @@
+package jepl_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chenyoufu/jepl"
+)
+
+// Ensure top() and bottom() select rows with their companion fields.
+func TestSelectors(t *testing.T) {
+	docs := []string{
+		`{"@timestamp": 10, "dst": "a", "bytes": 300, "tcp": {"src_ip": "10.0.0.1"}, "port": 80}`,
+		`{"@timestamp": 20, "dst": "a", "bytes": 100, "tcp": {"src_ip": "10.0.0.2"}, "port": 443}`,
+		`{"@timestamp": 30, "dst": "a", "bytes": 500, "tcp": {"src_ip": "10.0.0.3"}}`,
+		`{"@timestamp": 40, "dst": "a", "bytes": 300, "tcp": {"src_ip": "10.0.0.4"}, "port": 22}`,
+		`{"@timestamp": 70, "dst": "a", "bytes": 200, "tcp": {"src_ip": "10.0.0.5"}, "port": 80}`,
+		`{"@timestamp": 80, "dst": "b", "tcp": {"src_ip": "10.0.0.6"}}`,
+	}
+
+	for i, tt := range []struct {
+		s       string
+		columns []string
+		rows    map[string][][]interface{}
+	}{
+		{
+			s:       `select top(bytes, 3, tcp.src_ip, port) from foo`,
+			columns: []string{"top", "tcp.src_ip", "port"},
+			rows: map[string][][]interface{}{"": {
+				{float64(500), "10.0.0.3", nil},
+				{float64(300), "10.0.0.1", float64(80)},
+				{float64(300), "10.0.0.4", float64(22)},
+			}},
+		},
+		{
+			s:       `select bottom(bytes * 2, 2) AS least from foo group by dst`,
+			columns: []string{"least"},
+			rows: map[string][][]interface{}{
+				`dst='a'`: {{float64(200)}, {float64(400)}},
+				`dst='b'`: nil,
+			},
+		},
+		{
+			s:       `select top(bytes, 1, tcp.src_ip) from foo group by time(1m)`,
+			columns: []string{"top", "tcp.src_ip"},
+			rows: map[string][][]interface{}{"": {
+				{float64(500), "10.0.0.3"},
+				{float64(200), "10.0.0.5"},
+			}},
+		},
+	} {
+		res, err := jepl.ExecSQL(tt.s, docs)
+		if err != nil {
+			t.Fatalf("%d. %q: unexpected error: %s", i, tt.s, err)
+		}
+		if len(res) != len(tt.rows) {
+			t.Fatalf("%d. %q: series count mismatch: exp=%d got=%d", i, tt.s, len(tt.rows), len(res))
+		}
+		for k, exp := range tt.rows {
+			series := res.Get(k)
+			if !reflect.DeepEqual(tt.columns, series.Columns) {
+				t.Errorf("%d. %q: columns mismatch:\n  exp=%v\n  got=%v", i, tt.s, tt.columns, series.Columns)
+			}
+			var got [][]interface{}
+			for _, row := range series.Rows {
+				got = append(got, row.Values)
+			}
+			if !reflect.DeepEqual(exp, got) {
+				t.Errorf("%d. %q: %s: rows mismatch:\n  exp=%v\n  got=%v", i, tt.s, k, exp, got)
+			}
+			if len(series.Points) != len(exp)*len(tt.columns) {
+				t.Errorf("%d. %q: %s: unexpected points: %v", i, tt.s, k, series.Points)
+			}
+		}
+	}
+}
+
+// Ensure selectors are validated.
+func TestSelectors_Invalid(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		err string
+	}{
+		{s: `select top(bytes) from foo`, err: `invalid number of arguments for top, expected at least 2, got 1`},
+		{s: `select top(bytes, 0) from foo`, err: `top() expects a positive integer number of rows, got 0`},
+		{s: `select bottom(bytes, 1.5) from foo`, err: `bottom() expects a positive integer number of rows, got 1.5`},
+		{s: `select top(bytes, 2, src + 1) from foo`, err: `expected only field argument in top()`},
+		{s: `select top(bytes, 2), count(bytes) from foo`, err: `selector function top() cannot be combined with other fields`},
+		{s: `select top(bytes, 2) * 2 from foo`, err: `selector function top() cannot be used in an expression`},
+	} {
+		if _, err := jepl.ParseStatement(tt.s); errstring(err) != tt.err {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s", i, tt.s, tt.err, err)
+		}
+	}
+}
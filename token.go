@@ -16,16 +16,18 @@ const (
 
 	literalBeg
 	// IDENT and the following are InfluxQL literal tokens.
-	IDENT     // main
-	NUMBER    // 12345.67
-	INTEGER   // 12345
-	STRING    // "abc"
-	BADSTRING // "abc
-	BADESCAPE // \q
-	TRUE      // true
-	FALSE     // false
-	REGEX     // Regular expressions
-	BADREGEX  // `.*
+	IDENT       // main
+	NUMBER      // 12345.67
+	INTEGER     // 12345
+	DURATIONVAL // 13h
+	STRING      // "abc"
+	BADSTRING   // "abc
+	BADESCAPE   // \q
+	TRUE        // true
+	FALSE       // false
+	REGEX       // Regular expressions
+	BADREGEX    // `.*
+	BOUNDPARAM  // $param
 	literalEnd
 
 	operatorBeg
@@ -49,6 +51,15 @@ const (
 	LTE      // <=
 	GT       // >
 	GTE      // >=
+	IS       // IS
+	ISNOT    // IS NOT
+
+	BETWEEN    // BETWEEN
+	LIKE       // LIKE
+	ILIKE      // ILIKE
+	STARTSWITH // STARTS WITH
+	ENDSWITH   // ENDS WITH
+	CONTAINS   // CONTAINS
 	operatorEnd
 
 	LBRACKET // [
@@ -60,12 +71,24 @@ const (
 
 	keywordBeg
 	ALL
+	ANY
 	AS
+	DISTINCT
+	NOT
+	NULL
+	WITH
+	FILTER
 	FROM
 	SELECT
 	WHERE
 	GROUP
 	BY
+	HAVING
+	ORDER
+	ASC
+	DESC
+	LIMIT
+	OFFSET
 	keywordEnd
 )
 
@@ -74,15 +97,17 @@ var tokens = [...]string{
 	EOF:     "EOF",
 	WS:      "WS",
 
-	IDENT:     "IDENT",
-	NUMBER:    "NUMBER",
-	INTEGER:   "INTEGER",
-	STRING:    "STRING",
-	BADSTRING: "BADSTRING",
-	BADESCAPE: "BADESCAPE",
-	TRUE:      "TRUE",
-	FALSE:     "FALSE",
-	REGEX:     "REGEX",
+	IDENT:       "IDENT",
+	NUMBER:      "NUMBER",
+	INTEGER:     "INTEGER",
+	DURATIONVAL: "DURATIONVAL",
+	STRING:      "STRING",
+	BADSTRING:   "BADSTRING",
+	BADESCAPE:   "BADESCAPE",
+	TRUE:        "TRUE",
+	FALSE:       "FALSE",
+	REGEX:       "REGEX",
+	BOUNDPARAM:  "BOUNDPARAM",
 
 	ADD: "+",
 	SUB: "-",
@@ -103,6 +128,15 @@ var tokens = [...]string{
 	LTE:      "<=",
 	GT:       ">",
 	GTE:      ">=",
+	IS:       "IS",
+	ISNOT:    "IS NOT",
+
+	BETWEEN:    "BETWEEN",
+	LIKE:       "LIKE",
+	ILIKE:      "ILIKE",
+	STARTSWITH: "STARTS WITH",
+	ENDSWITH:   "ENDS WITH",
+	CONTAINS:   "CONTAINS",
 
 	LBRACKET: "[",
 	LPAREN:   "(",
@@ -111,13 +145,25 @@ var tokens = [...]string{
 	COMMA:    ",",
 	DOT:      ".",
 
-	ALL:    "ALL",
-	AS:     "AS",
-	FROM:   "FROM",
-	SELECT: "SELECT",
-	WHERE:  "WHERE",
-	GROUP:  "GROUP",
-	BY:     "BY",
+	ALL:      "ALL",
+	ANY:      "ANY",
+	AS:       "AS",
+	DISTINCT: "DISTINCT",
+	NOT:      "NOT",
+	NULL:     "NULL",
+	WITH:     "WITH",
+	FILTER:   "FILTER",
+	FROM:     "FROM",
+	SELECT:   "SELECT",
+	WHERE:    "WHERE",
+	GROUP:    "GROUP",
+	BY:       "BY",
+	HAVING:   "HAVING",
+	ORDER:    "ORDER",
+	ASC:      "ASC",
+	DESC:     "DESC",
+	LIMIT:    "LIMIT",
+	OFFSET:   "OFFSET",
 }
 
 var keywords map[string]Token
@@ -127,9 +173,11 @@ func init() {
 	for tok := keywordBeg + 1; tok < keywordEnd; tok++ {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
-	for _, tok := range []Token{AND, OR, IN, NI} {
+	for _, tok := range []Token{AND, OR, IN, NI, IS, BETWEEN, LIKE, ILIKE, CONTAINS} {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
+	keywords["starts"] = STARTSWITH
+	keywords["ends"] = ENDSWITH
 	keywords["true"] = TRUE
 	keywords["false"] = FALSE
 }
@@ -151,7 +199,8 @@ func (tok Token) Precedence() int {
 		return 2
 	case IN, NI:
 		return 3
-	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE:
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE, IS, ISNOT,
+		BETWEEN, LIKE, ILIKE, STARTSWITH, ENDSWITH, CONTAINS:
 		return 4
 	case ADD, SUB:
 		return 5